@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bangn/bookings/internal/repository"
+)
+
+// bookingDraftRetention is how long a booking draft is kept once it expired, so the guest who booked it
+// can still open its summary
+const bookingDraftRetention = time.Hour
+
+// cleanUpBookingDrafts removes the booking drafts expired for longer than bookingDraftRetention, every interval.
+// Expired drafts hold no room already, see sweepExpiredHolds, this only keeps the table small. It runs until ctx is done, see startWorker
+func cleanUpBookingDrafts(ctx context.Context, db repository.DatabaseRepo, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := db.DeleteExpiredBookingDrafts(ctx, time.Now().Add(-bookingDraftRetention))
+		if err != nil {
+			app.Logger.Error("failed to remove expired booking drafts", "error", err)
+			continue
+		}
+		if n > 0 {
+			app.Logger.Debug("removed expired booking drafts", "count", n)
+		}
+	}
+}
+
+// sweepExpiredHolds removes the room holds of the guests who did not finish booking in time, every interval.
+// The availability queries already ignore expired holds, this keeps them out of room_restrictions.
+// It runs until ctx is done, see startWorker
+func sweepExpiredHolds(ctx context.Context, db repository.DatabaseRepo, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := db.DeleteExpiredHolds(ctx, time.Now())
+		if err != nil {
+			app.Logger.Error("failed to remove expired room holds", "error", err)
+			continue
+		}
+		if n > 0 {
+			app.Logger.Debug("removed expired room holds", "count", n)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bangn/bookings/internal/icalsync"
+	"github.com/bangn/bookings/internal/repository"
+)
+
+// syncCalendarFeeds imports the calendar feeds of every room, every interval, so the nights booked
+// on other platforms are blocked here. It runs until ctx is done, see startWorker
+func syncCalendarFeeds(ctx context.Context, db repository.DatabaseRepo, interval time.Duration) {
+	syncer := icalsync.New(db)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := syncer.SyncAll(ctx)
+		if err != nil {
+			app.Logger.Error("failed to sync calendar feeds", "error", err)
+		}
+		if result.Conflicts > 0 {
+			app.Logger.Error("imported bookings overlap reservations, rooms are double booked", "conflicts", result.Conflicts)
+		}
+		if result.Added+result.Updated+result.Removed > 0 {
+			app.Logger.Info("calendar feeds synced", "added", result.Added, "updated", result.Updated, "removed", result.Removed)
+		}
+	}
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -13,123 +21,193 @@ import (
 	"github.com/bangn/bookings/internal/driver"
 	"github.com/bangn/bookings/internal/handlers"
 	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/logging"
+	"github.com/bangn/bookings/internal/metrics"
 	"github.com/bangn/bookings/internal/models"
 	"github.com/bangn/bookings/internal/render"
+	"github.com/bangn/bookings/internal/repository/dbrepo"
 	"github.com/joho/godotenv"
 )
 
-const portNumber = ":8080"
+var settings config.Settings
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
-
 
 // main is the main function of the application
 func main() {
-	db, err := run()
-	
+	_, err := run(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to start", err)
 	}
 
-	defer db.SQL.Close()
-
 	// ---------------------------------------------
 	// set up routes
 	// ---------------------------------------------
-	fmt.Printf("Starting server at port http://localhost%s\n", portNumber)
+	app.Logger.Info("starting server", "addr", settings.Addr, "env", settings.Env)
 
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    settings.Addr,
 		Handler: routes(&app),
 	}
 
-	// listen for a request
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	ln, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+		defer cancel()
+		fatal("failed to listen", errors.Join(err, app.Shutdown.Run(ctx)))
+	}
+
+	// listen for requests until a deploy or Ctrl-C stops the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// a second signal kills the process at once
+		<-ctx.Done()
+		stop()
+	}()
+
+	err = serve(ctx, srv, ln, settings.ShutdownTimeout)
+	if err != nil {
+		fatal("failed to stop cleanly", err)
+	}
+	app.Logger.Info("stopped")
+}
+
+// fatal logs err and exits, with the standard logger when the settings could not be read and there is no app.Logger yet
+func fatal(msg string, err error) {
+	if app.Logger == nil {
+		log.Fatalf("%s: %v", msg, err)
+	}
+	app.Logger.Error(msg, "error", err)
+	os.Exit(1)
 }
 
-func run() (*driver.DB, error) {
+// run sets the application up with the settings read from the command line args, the environment and the settings file
+func run(args []string) (db *driver.DB, err error) {
 	// ---------------------------------------------
 	// add place to store objects in session
 	// ---------------------------------------------
-	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
-	// This is necessary because the session manager needs to know how to encode and decode the Reservation struct when storing and retrieving it from the session.
-	// Remember that, we only put map of any(map[string]interface{}) in template data, thus we need to register the struct that we want to put in the map, 
-	// so that session can serialize and deserialize it correctly.
-	// By registering the Reservation struct with gob, we ensure that the session manager can handle it properly when we store it in the session and retrieve it later.
-	// If we don't register the struct, we may encounter errors when trying to store or retrieve Reservation objects from the session, because Go does not have built-in serialization for custom types, so it does not understand how to convert the Reservation struct to a format suitable for storage in the session (like a byte slice) and back.
-	// consequently, we register any custom types that we plan to store in the session to avoid serialization issues.
-	// ---------------------------------------------
-	// The encoding/gob package is used for this purpose, and by registering the struct, we ensure that the session manager can handle it correctly.
+	// The session manager encodes the values put in the session with encoding/gob, which has to know
+	// the custom types beforehand. The booking funnel keeps its reservation in a booking draft now,
+	// the session only has the ID of the last one, but sessions saved before still carry a reservation
+	// and would fail to load without it.
+	gob.Register(models.Reservation{})
 
-	// Load env
-	err := godotenv.Load()
+	// ---------------------------------------------
+	// load settings, a .env file is optional and only fills in variables not set already
+	// ---------------------------------------------
+	err = godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	settings, err = config.Load(args, os.Getenv)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return nil, err
 	}
-	dbPassword := fmt.Sprintf(os.Getenv("DATABASE_PASSWORD"))
-	dbName := fmt.Sprintf(os.Getenv("DATABASE_NAME"))
 
-	// <<<<<<<<<<<<<<<<<<<<<<<<<<<<<
-	// set this to true in production
-	app.InProduction = false
-	// >>>>>>>>>>>>>>>>>>>>>>>>>>>>
+	app.InProduction = settings.InProduction()
+	app.Shutdown = &config.Shutdown{}
+
+	// a step failing after the mailer or the database pool is started stops them again
+	defer func() {
+		if err != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout)
+			defer cancel()
+			err = errors.Join(err, app.Shutdown.Run(ctx))
+		}
+	}()
 
 	// ---------------------------------------------
-	// create loggers
+	// create the logger, JSON in production, it is also the default one of the log and slog packages
 	// ---------------------------------------------
-	infoLog = log.New(os.Stdout, "[INFO]\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog = log.New(os.Stdout, "[ERROR]\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logging.New(os.Stdout, app.InProduction)
+	slog.SetDefault(app.Logger)
 
 	// ---------------------------------------------
-	// create session configuration parameters// ---------------------------------------------
+	// start the mailer, handlers queue mails on app.MailChan.
+	// At shutdown the queue is closed, no handler runs any more, and the mails left in it are sent
 	// ---------------------------------------------
-	session = scs.New()
-	session.Lifetime = 24 * time.Hour
-	session.Cookie.Persist = true
-	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
+	app.BaseURL = settings.BaseURL
+	app.OwnerEmail = settings.Mail.OwnerEmail
 
-	app.Session = session
+	mailChan := make(chan models.MailData, 100)
+	app.MailChan = mailChan
+
+	m, err := newMailer(settings.Mail)
+	if err != nil {
+		return nil, err
+	}
+	mailerDone := make(chan struct{})
+	go func() {
+		defer close(mailerDone)
+		m.Listen(mailChan)
+	}()
+	app.Shutdown.OnShutdown("mailer", func(ctx context.Context) error {
+		close(mailChan)
+		return waitFor(ctx, mailerDone)
+	})
+
+	// ---------------------------------------------
+	// set up payments, unpaid reservations are expired once the DB is connected
+	// ---------------------------------------------
+	app.Payments, err = newPaymentProvider(settings.Payments)
+	if err != nil {
+		return nil, err
+	}
+	app.PaymentTimeout = settings.Payments.Timeout
+	app.CalendarSyncInterval = settings.CalendarSyncInterval
+	app.BookingHold = settings.BookingHold
 
 	// ---------------------------------------------
 	// connect to database
 	// ---------------------------------------------
-	log.Println("Connecting to DB...")
-	db, err := driver.ConnectSQL(fmt.Sprintf("host=localhost port=5432 dbname=%s user=admin password=%s", dbName, dbPassword))
+	app.QueryTimeout = settings.Database.QueryTimeout
+	app.Logger.Info("connecting to the database")
+	db, err = driver.ConnectSQL(settings.Database.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the database: %w", err)
+	}
+	app.Logger.Info("connected to the database")
+
+	// the hooks run in reverse order, the pool is closed once the workers started below are stopped
+	app.Shutdown.OnShutdown("database", func(ctx context.Context) error {
+		return db.SQL.Close()
+	})
+
+	// the pool statistics are served on /metrics
+	metrics.Default.Register(metrics.DBStats(db.SQL))
+
+	// ---------------------------------------------
+	// create session configuration parameters, the sessions are kept in Postgres unless the settings say otherwise
+	// ---------------------------------------------
+	session, err = newSessionManager(settings.Session, db.SQL, app.QueryTimeout, app.InProduction)
 	if err != nil {
-		log.Fatal("Failed to connect to DB")
 		return nil, err
 	}
-	log.Println("[INFO]Connected to DB successfully")
+
+	app.Session = session
 
 	// ---------------------------------------------
 	// create cache for templates to render later
 	// ---------------------------------------------
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("Can not create template cache")
-		return nil, err
+		return nil, fmt.Errorf("creating the template cache: %w", err)
 	}
 
 	// ---------------------------------------------
 	// add template cache to app config
 	// ---------------------------------------------
 	app.TemplateCache = tc
-	app.UseCache = false // development mode, the template will be reloaded on every request, 
-	// not use the global cache, because the templates may change frequently, but in production mode, set it to true,
-	// because no one changes the templates in the backend frequently
+	app.UseCache = settings.TemplateCache() // off in development, the templates are reloaded on every request,
+	// because they change frequently there, in production nobody changes them so they are parsed once
 
-	
 	// ---------------------------------------------
 	// set the app config to the render package
 	// ---------------------------------------------
@@ -146,6 +224,21 @@ func run() (*driver.DB, error) {
 	// ---------------------------------------------
 	helpers.NewHelpers(&app)
 
+	// ---------------------------------------------
+	// start the background workers, last so that nothing can fail once they run
+	// ---------------------------------------------
+	startWorker("payment expiry", func(ctx context.Context) {
+		expireUnpaidReservations(ctx, dbrepo.NewPostgresRepo(&app, db.SQL), time.Minute)
+	})
+	startWorker("calendar sync", func(ctx context.Context) {
+		syncCalendarFeeds(ctx, dbrepo.NewPostgresRepo(&app, db.SQL), app.CalendarSyncInterval)
+	})
+	startWorker("booking draft cleanup", func(ctx context.Context) {
+		cleanUpBookingDrafts(ctx, dbrepo.NewPostgresRepo(&app, db.SQL), 5*time.Minute)
+	})
+	startWorker("room hold sweep", func(ctx context.Context) {
+		sweepExpiredHolds(ctx, dbrepo.NewPostgresRepo(&app, db.SQL), time.Minute)
+	})
 
 	return db, nil
 }
\ No newline at end of file
@@ -1,12 +1,38 @@
 package main
 
 import (
+	"strings"
 	"testing"
 )
 
 func TestRun(t *testing.T) {
-	_, err := run()
+	_, err := run(nil)
 	if err != nil {
 		t.Fatal("failed run(): ", err)
 	}
-}
\ No newline at end of file
+}
+
+// TestRun_Production starts with the settings of a production machine that takes no payment online.
+// There is no database listening, run has to get as far as connecting to it and stop the mailer it started
+func TestRun_Production(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("DATABASE_URL", "postgres://bookings@127.0.0.1:1/bookings?connect_timeout=1")
+	t.Setenv("PAYMENT_PROVIDER", "none")
+
+	_, err := run(nil)
+	if err == nil || !strings.Contains(err.Error(), "connecting to the database") {
+		t.Fatalf("expected run to stop at the database, got %v", err)
+	}
+	if app.Payments != nil {
+		t.Errorf("no payment is taken online, got provider %T", app.Payments)
+	}
+	select {
+	case _, open := <-app.MailChan:
+		if open {
+			t.Error("expected the mail queue to be empty")
+		}
+	default:
+		t.Error("expected the mail queue to be closed")
+	}
+}
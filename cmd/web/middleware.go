@@ -1,14 +1,32 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/bangn/bookings/internal/handlers"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/logging"
+	"github.com/bangn/bookings/internal/metrics"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 )
 
-// Nosurf add scrf protection to all POST request
+// Nosurf add scrf protection to all POST request,
+// except for the JSON API whose clients authenticate with an API key instead of a cookie
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// API clients authenticate with keys and the payment provider signs its webhooks, neither has the CSRF cookie
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/payments/webhook"
+	})
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -23,4 +41,105 @@ func NoSurf(next http.Handler) http.Handler {
 // SessionLoad loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
-}
\ No newline at end of file
+}
+
+// Auth only lets staff users (see models.AccessLevelAdmin) through, everybody else is sent to the login page.
+// The access level is read from the database on every request, like APIAuth does, so a user who is demoted
+// or deleted loses the admin area at once rather than when the session expires
+func Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.IsAuthenticated(r) {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		user, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			// the user was deleted, the session does not belong to anybody any more
+			_ = session.Destroy(r.Context())
+			_ = session.RenewToken(r.Context())
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		if user.AccessLevel < models.AccessLevelAdmin {
+			helpers.ClientError(w, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Metrics counts the requests and their latency by route pattern rather than path,
+// so /rooms/{slug} is one series whatever the room
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			route := routePattern(r)
+			metrics.HTTPRequests.Inc(r.Method, route, strconv.Itoa(status(ww)))
+			metrics.HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// AccessLog logs every request once it is answered, under the ID the RequestID middleware gave it,
+// which is also sent back in the X-Request-Id header so a guest reporting an error can quote it
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			if id := logging.RequestID(r.Context()); id != "" {
+				ww.Header().Set(middleware.RequestIDHeader, id)
+			}
+
+			defer func() {
+				code := status(ww)
+				level := slog.LevelInfo
+				if code >= http.StatusInternalServerError {
+					level = slog.LevelError
+				}
+
+				logger.LogAttrs(r.Context(), level, "request",
+					slog.String("method", r.Method),
+					slog.String("route", routePattern(r)),
+					slog.String("path", r.URL.Path),
+					slog.Int("status", code),
+					slog.Int("bytes", ww.BytesWritten()),
+					slog.Duration("duration", time.Since(start)),
+				)
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// routePattern is the chi route pattern the request matched, unmatched for the not found pages.
+// It is only known once the routers have matched the request
+func routePattern(r *http.Request) string {
+	route := chi.RouteContext(r.Context()).RoutePattern()
+	if route == "" || route == "/*" {
+		return "unmatched"
+	}
+	return route
+}
+
+// status is the status code of the response, handlers writing a body without a header answer 200
+func status(ww middleware.WrapResponseWriter) int {
+	if ww.Status() == 0 {
+		return http.StatusOK
+	}
+	return ww.Status()
+}
@@ -1,8 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/bangn/bookings/internal/handlers"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/logging"
+	"github.com/bangn/bookings/internal/metrics"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -17,6 +28,24 @@ func TestNoSurf(t *testing.T) {
 	}
 }
 
+func TestNoSurf_ExemptsAPI(t *testing.T) {
+	h := NoSurf(&myHandler{})
+
+	for path, expected := range map[string]int{
+		"/api/v1/reservations": http.StatusOK,
+		"/payments/webhook":    http.StatusOK,
+		"/make-reservation":    http.StatusBadRequest,
+		"/payments/other":      http.StatusBadRequest,
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+
+		if rr.Code != expected {
+			t.Errorf("POST %s without CSRF token: got %d, wanted %d", path, rr.Code, expected)
+		}
+	}
+}
+
 func TestSessionLoad(t *testing.T) {
 	myH := myHandler{}
 	h := SessionLoad(&myH)
@@ -27,4 +56,114 @@ func TestSessionLoad(t *testing.T) {
 	default:
 		t.Errorf("SessionLoad returned wrong type: %T", v)
 	}
-}
\ No newline at end of file
+}
+
+// the users are those of the test repository, user 1 is an admin and user 2 a regular user
+var authTests = []struct {
+	name             string
+	userID           int
+	expectedStatus   int
+	expectedLocation string
+}{
+	{"anonymous", 0, http.StatusSeeOther, "/user/login"},
+	{"user", 2, http.StatusForbidden, ""},
+	{"deleted user", 99, http.StatusSeeOther, "/user/login"},
+	{"admin", 1, http.StatusOK, ""},
+}
+
+func TestAuth(t *testing.T) {
+	defer func(s *scs.SessionManager) {
+		session = s
+		app.Session = s
+	}(session)
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelpers(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+
+	for _, e := range authTests {
+		// logs the user of the test in, before Auth looks at the session
+		login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if e.userID > 0 {
+				session.Put(r.Context(), "user_id", e.userID)
+			}
+			Auth(&myHandler{}).ServeHTTP(w, r)
+		})
+
+		rr := httptest.NewRecorder()
+		session.LoadAndSave(login).ServeHTTP(rr, httptest.NewRequest("GET", "/admin/dashboard", nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, location, e.expectedLocation)
+		}
+	}
+}
+
+func TestMetrics_CountsByRoutePattern(t *testing.T) {
+	site := chi.NewRouter()
+	site.Get("/rooms/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	mux := chi.NewRouter()
+	mux.Use(Metrics)
+	mux.Mount("/", site)
+
+	for _, path := range []string{"/rooms/generals-quarters", "/rooms/majors-suite", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// the pattern of the mounted router is reported without the mount point
+	if v := metrics.HTTPRequests.Value("GET", "/rooms/{slug}", "418"); v != 2 {
+		t.Errorf("expected 2 requests to /rooms/{slug}, got %v", v)
+	}
+	if v := metrics.HTTPRequests.Value("GET", "unmatched", "404"); v != 1 {
+		t.Errorf("expected 1 unmatched request, got %v", v)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	site := chi.NewRouter()
+	site.Get("/reservations/{token}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+
+	mux := chi.NewRouter()
+	mux.Use(middleware.RequestID)
+	mux.Use(AccessLog(logging.New(&buf, true)))
+	mux.Mount("/", site)
+
+	req := httptest.NewRequest("GET", "/reservations/secret-token", nil)
+	req.Header.Set(middleware.RequestIDHeader, "lb-1234")
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Header().Get(middleware.RequestIDHeader) != "lb-1234" {
+		t.Errorf("the request ID is sent back, got %q", rr.Header().Get(middleware.RequestIDHeader))
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	for key, expected := range map[string]any{
+		"level":      "ERROR",
+		"msg":        "request",
+		"method":     "GET",
+		"route":      "/reservations/{token}",
+		"status":     float64(http.StatusInternalServerError),
+		"bytes":      float64(len("boom\n")),
+		"request_id": "lb-1234",
+	} {
+		if record[key] != expected {
+			t.Errorf("%s: got %v, expected %v", key, record[key], expected)
+		}
+	}
+	if _, ok := record["duration"]; !ok {
+		t.Error("the latency is missing")
+	}
+}
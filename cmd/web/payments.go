@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/metrics"
+	"github.com/bangn/bookings/internal/payments"
+	"github.com/bangn/bookings/internal/repository"
+)
+
+// newPaymentProvider builds the payment provider of the settings. There is none with the provider none,
+// no payment is taken online and reservations are left new for staff to confirm
+func newPaymentProvider(s config.PaymentSettings) (payments.PaymentProvider, error) {
+	switch s.Provider {
+	case "fake":
+		return payments.NewFakeProvider(s.WebhookSecret), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", s.Provider)
+	}
+}
+
+// expireUnpaidReservations cancels the reservations left unpaid for longer than app.PaymentTimeout,
+// every interval, so their rooms can be booked again. It runs until ctx is done, see startWorker
+func expireUnpaidReservations(ctx context.Context, db repository.DatabaseRepo, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := db.ExpirePendingReservations(ctx, time.Now().Add(-app.PaymentTimeout))
+		if err != nil {
+			app.Logger.Error("failed to expire unpaid reservations", "error", err)
+			continue
+		}
+		if n > 0 {
+			metrics.ReservationsExpired.Add(float64(n))
+			app.Logger.Info("cancelled unpaid reservations", "count", n)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/bangn/bookings/internal/config"
 	"github.com/bangn/bookings/internal/handlers"
+	"github.com/bangn/bookings/internal/metrics"
+	"github.com/bangn/bookings/internal/models"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -12,13 +14,35 @@ import (
 func routes(app *config.AppConfig) http.Handler {
 	// mux := pat.New()
 	mux := chi.NewRouter()
-	
+
+	// give every request an ID, taken from the X-Request-Id header of the load balancer when it sets one,
+	// the logs of the request carry it
+	mux.Use(middleware.RequestID)
+	mux.Use(AccessLog(app.Logger))
+
+	// count every request and its latency by route pattern, for /metrics
+	mux.Use(Metrics)
+
 	// Use Chi middlewares
 	// recover from panics (user request ), log request info, set secure headers
 	// It handles the error gracefully by returning an http.StatusInternalServerError (500)
 	// a bit similar to what next(err) function does in nodejs/express, where all the subsequent handlers/middlewares are skipped
 	mux.Use(middleware.Recoverer)
 
+	// probes of the load balancer and the metrics scraper, they get neither a CSRF cookie nor a session
+	mux.Get("/healthz", handlers.Repo.Healthz)
+	mux.Get("/readyz", handlers.Repo.Readyz)
+	mux.Method("GET", "/metrics", metrics.Default)
+
+	mux.Mount("/", siteRoutes(app))
+
+	return mux
+}
+
+// siteRoutes are the pages, the JSON API and the back-office
+func siteRoutes(app *config.AppConfig) http.Handler {
+	mux := chi.NewRouter()
+
 	// protect against CSRF attacks
 	mux.Use(NoSurf)
 
@@ -27,18 +51,80 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", handlers.Repo.RoomCalendar)
+	// the rooms used to have hand-written pages
+	mux.Method("GET", "/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Method("GET", "/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	// the booking funnel, keyed by the booking draft so every tab books on its own
+	mux.Get("/bookings/{draft}/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Get("/bookings/{draft}/choose-rooms", handlers.Repo.ChooseRooms)
+	mux.Get("/bookings/{draft}", handlers.Repo.Reservation)
+	mux.Post("/bookings/{draft}", handlers.Repo.PostReservation)
+	mux.Get("/bookings/{draft}/summary", handlers.Repo.ReservationSummary)
+	mux.Get("/make-reservation", handlers.Repo.ResumeBooking)
+	mux.Get("/reservation-summary", handlers.Repo.ResumeBooking)
+	mux.Get("/reservations/{token}", handlers.Repo.GuestReservation)
+	mux.Post("/reservations/{token}/change", handlers.Repo.PostGuestChangeReservation)
+	mux.Post("/reservations/{token}/cancel", handlers.Repo.PostGuestCancelReservation)
+	mux.Post("/reservations/{token}/pay", handlers.Repo.PostGuestPayReservation)
+	mux.Get("/reservations/{token}/calendar.ics", handlers.Repo.GuestReservationCalendar)
+	// signed by the payment provider, see NoSurf
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
 	mux.Get("/contact", handlers.Repo.Contact)
-	
+
+	mux.Get("/user/login", handlers.Repo.ShowLogin)
+	mux.Post("/user/login", handlers.Repo.PostLogin)
+	mux.Get("/user/logout", handlers.Repo.Logout)
+
+	// versioned JSON API
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+
+		// every endpoint needs an API key, reading reservations needs an admin one
+		mux.Use(handlers.Repo.APIAuth)
+
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/rooms/{id}/availability", handlers.Repo.APIRoomAvailability)
+		mux.Get("/rooms/{id}/quote", handlers.Repo.APIRoomQuote)
+		mux.Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.With(handlers.Repo.APIScope(models.AccessLevelAdmin)).Get("/reservations/{id}", handlers.Repo.APIGetReservation)
+	})
+
+	// back-office for staff, every route below requires an admin login
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(Auth)
+
+		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
+		mux.Post("/reservations/{src}/{id}/process", handlers.Repo.AdminProcessReservation)
+		mux.Post("/reservations/{src}/{id}/refund", handlers.Repo.AdminRefundReservation)
+		mux.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.Post("/rooms/{id}/delete", handlers.Repo.AdminDeleteRoom)
+		mux.Post("/rooms/{id}/calendar-token", handlers.Repo.AdminResetRoomCalendarToken)
+		mux.Post("/rooms/{id}/feeds", handlers.Repo.AdminPostRoomCalendarFeed)
+		mux.Post("/rooms/{id}/feeds/{feedID}/sync", handlers.Repo.AdminSyncRoomCalendarFeed)
+		mux.Post("/rooms/{id}/feeds/{feedID}/delete", handlers.Repo.AdminDeleteRoomCalendarFeed)
+		mux.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+		mux.Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+		mux.Post("/api-keys/{id}/revoke", handlers.Repo.AdminRevokeAPIKey)
+	})
 	
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
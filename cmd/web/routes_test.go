@@ -1,6 +1,9 @@
 package main
 
 import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/bangn/bookings/internal/config"
@@ -18,4 +21,19 @@ func TestRoutes(t *testing.T) {
 	default:
 		t.Errorf("routes returned wrong type: %T", v)
 	}
-}
\ No newline at end of file
+}
+func TestRoutes_ProbesHaveNoSession(t *testing.T) {
+	mux := routes(&config.AppConfig{Logger: slog.New(slog.DiscardHandler)})
+
+	for _, path := range []string{"/healthz", "/metrics"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, wanted 200", path, rr.Code)
+		}
+		if cookies := rr.Result().Cookies(); len(cookies) > 0 {
+			t.Errorf("GET %s: the probes get neither a CSRF cookie nor a session, got %v", path, cookies)
+		}
+	}
+}
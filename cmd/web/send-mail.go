@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/mailer"
+)
+
+// newMailer builds the mailer of the settings,
+// the smtp transport sends through SMTPHost, the file transport writes .eml files to Dir
+func newMailer(s config.MailSettings) (*mailer.Mailer, error) {
+	var transport mailer.Transport
+
+	switch s.Transport {
+	case "smtp":
+		transport = &mailer.SMTPTransport{
+			Host:     s.SMTPHost,
+			Port:     s.SMTPPort,
+			Username: s.SMTPUsername,
+			Password: s.SMTPPassword,
+		}
+	default:
+		transport = &mailer.FileTransport{Dir: s.Dir}
+	}
+
+	return mailer.New(transport, "./templates/email", s.From, app.Logger.With("worker", "mailer")), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/sessionstore"
+)
+
+// newSessionManager returns the session manager of the settings. Sessions kept in Postgres survive restarts
+// and are shared by every instance, their expired rows are removed in the background until the application stops
+func newSessionManager(s config.SessionSettings, db *sql.DB, queryTimeout time.Duration, secure bool) (*scs.SessionManager, error) {
+	sm := scs.New()
+	sm.Lifetime = s.Lifetime
+	sm.Cookie.Persist = true
+	sm.Cookie.SameSite = http.SameSiteLaxMode
+	sm.Cookie.Secure = secure
+
+	switch s.Store {
+	case config.SessionStoreMemory:
+		// the store of scs.New
+	case config.SessionStorePostgres:
+		store := sessionstore.New(db, queryTimeout)
+		sm.Store = store
+		startWorker("session cleanup", func(ctx context.Context) {
+			cleanUpSessions(ctx, store, s.CleanupInterval)
+		})
+	default:
+		return nil, fmt.Errorf("unknown session store %q", s.Store)
+	}
+
+	return sm, nil
+}
+
+// cleanUpSessions removes the expired sessions every interval. It runs until ctx is done, see startWorker
+func cleanUpSessions(ctx context.Context, store *sessionstore.PostgresStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := store.DeleteExpired(ctx)
+		if err != nil {
+			app.Logger.Error("failed to remove expired sessions", "error", err)
+			continue
+		}
+		if n > 0 {
+			app.Logger.Debug("removed expired sessions", "count", n)
+		}
+	}
+}
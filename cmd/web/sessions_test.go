@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/sessionstore"
+)
+
+func TestNewSessionManager(t *testing.T) {
+	app.Shutdown = &config.Shutdown{}
+	// nothing is queried until a request comes
+	db, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	settings := config.DefaultSettings().Session
+
+	sm, err := newSessionManager(settings, db, time.Second, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sm.Store.(*sessionstore.PostgresStore); !ok {
+		t.Errorf("the sessions are kept in Postgres by default, got %T", sm.Store)
+	}
+	if sm.Lifetime != 24*time.Hour || !sm.Cookie.Secure {
+		t.Errorf("the settings were not applied: %s, secure %v", sm.Lifetime, sm.Cookie.Secure)
+	}
+
+	// the cleanup worker stops with the application
+	if err := app.Shutdown.Run(context.Background()); err != nil {
+		t.Errorf("stopping the session cleanup: %v", err)
+	}
+
+	settings.Store = config.SessionStoreMemory
+	sm, err = newSessionManager(settings, nil, time.Second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sm.Store.(*memstore.MemStore); !ok {
+		t.Errorf("expected the memory store, got %T", sm.Store)
+	}
+
+	settings.Store = "redis"
+	if _, err := newSessionManager(settings, nil, time.Second, false); err == nil {
+		t.Error("expected an error for an unknown store")
+	}
+}
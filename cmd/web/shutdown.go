@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// waitFor waits until done is closed, or ctx is done
+func waitFor(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startWorker runs fn in its own goroutine until the application shuts down: ctx is cancelled then,
+// and the shutdown waits for fn to return
+func startWorker(name string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+
+	app.Shutdown.OnShutdown(name, func(shutdownCtx context.Context) error {
+		cancel()
+		return waitFor(shutdownCtx, done)
+	})
+}
+
+// serve answers requests on ln until ctx is done. It then stops taking new requests, waits up to timeout
+// for those in flight and runs the shutdown hooks of app within what is left of the timeout
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, timeout time.Duration) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		app.Logger.Info("shutting down, waiting for requests in flight", "timeout", timeout)
+	case err = <-serveErr:
+		// the server failed on its own, the workers are stopped all the same
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+		err = errors.Join(err, fmt.Errorf("draining requests: %w", shutdownErr))
+		srv.Close()
+	}
+	if hooksErr := app.Shutdown.Run(shutdownCtx); hooksErr != nil {
+		err = errors.Join(err, hooksErr)
+	}
+
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bangn/bookings/internal/config"
+)
+
+func TestServe_DrainsRequestsInFlight(t *testing.T) {
+	app.Logger = slog.New(slog.DiscardHandler)
+	app.Shutdown = &config.Shutdown{}
+
+	var workerStopped bool
+	startWorker("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		workerStopped = true
+	})
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("booked"))
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, srv, ln, 5*time.Second)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- response{string(body), err}
+	}()
+
+	// the signal arrives while the request is being answered
+	<-started
+	cancel()
+
+	resp := <-responses
+	if resp.err != nil || resp.body != "booked" {
+		t.Errorf("the request in flight was cut: %q, %v", resp.body, resp.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve returned %v", err)
+	}
+	if !workerStopped {
+		t.Error("the worker was not stopped")
+	}
+
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("the server still takes requests after the shutdown")
+	}
+}
+
+func TestServe_DrainTimeout(t *testing.T) {
+	app.Logger = slog.New(slog.DiscardHandler)
+	app.Shutdown = &config.Shutdown{}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, srv, ln, 50*time.Millisecond)
+	}()
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	cancel()
+
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("expected the drain timeout to be reported")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve did not give up after the drain timeout")
+	}
+}
@@ -1,18 +1,38 @@
 package config
 
 import (
-	"log"
-	"text/template"
+	"html/template"
+	"log/slog"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/payments"
 )
 
 // AppConfig holds the application configuration
 type AppConfig struct {
 	UseCache     bool
 	TemplateCache map[string]*template.Template
-	InfoLog      *log.Logger
-	ErrorLog     *log.Logger
+	// Logger writes structured logs, JSON in production, see logging.New
+	Logger       *slog.Logger
 	InProduction bool
 	Session *scs.SessionManager
+	// QueryTimeout is the time a repository call gets, the request it serves may end it sooner
+	QueryTimeout time.Duration
+	// MailChan queues mails for the background mailer
+	MailChan   chan models.MailData
+	OwnerEmail string
+	// BaseURL is the public address of the site, used for links in mails
+	BaseURL string
+	// Payments takes the payment of reservations, unpaid ones are cancelled after PaymentTimeout.
+	// It is nil when no payment is taken online
+	Payments       payments.PaymentProvider
+	PaymentTimeout time.Duration
+	// CalendarSyncInterval is how often the calendar feeds of other platforms are imported
+	CalendarSyncInterval time.Duration
+	// BookingHold is how long a booking draft holds the rooms the guest chose, see models.BookingDraft
+	BookingHold time.Duration
+	// Shutdown stops the background workers and closes the database pool when the application exits
+	Shutdown *Shutdown
 }
\ No newline at end of file
@@ -0,0 +1,437 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the environments the application runs in
+const (
+	EnvDevelopment = "development"
+	EnvTest        = "test"
+	EnvProduction  = "production"
+)
+
+// the stores the sessions are kept in
+const (
+	SessionStoreMemory   = "memory"
+	SessionStorePostgres = "postgres"
+)
+
+// the payment providers. The fake one takes no money, its webhook secret is the default one, known to anybody reading
+// the source, neither is accepted in production. With none no payment is taken online, staff confirm the reservations
+const (
+	fakePaymentProvider = "fake"
+	fakeWebhookSecret   = "fake-webhook-secret"
+	noPaymentProvider   = "none"
+)
+
+// DefaultSettingsFile is read when it exists and no other file is given with -config or CONFIG_FILE
+const DefaultSettingsFile = "bookings.yml"
+
+// Settings are the values the application starts with. Load reads them from, by order of precedence,
+// the command line flags, the environment variables and the YAML settings file, the defaults fill in the rest
+type Settings struct {
+	// Env is development, test or production
+	Env string `yaml:"env"`
+	// Addr is the address the web server listens on, such as :8080
+	Addr    string `yaml:"addr"`
+	BaseURL string `yaml:"base_url"`
+	// ShutdownTimeout is how long requests in flight and background workers get to finish when the application stops
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// UseCache keeps the templates parsed at start, when not set it is on in production only
+	UseCache *bool `yaml:"use_cache"`
+
+	Database DatabaseSettings `yaml:"database"`
+	Session  SessionSettings  `yaml:"session"`
+	Mail     MailSettings     `yaml:"mail"`
+	Payments PaymentSettings  `yaml:"payments"`
+
+	// CalendarSyncInterval is how often the calendar feeds of other platforms are imported
+	CalendarSyncInterval time.Duration `yaml:"calendar_sync_interval"`
+	// BookingHold is how long a booking draft holds the rooms the guest chose
+	BookingHold time.Duration `yaml:"booking_hold"`
+}
+
+// DatabaseSettings say how to connect to Postgres, URL wins over the other fields when it is set.
+// The keys are those of database.yml
+type DatabaseSettings struct {
+	URL      string `yaml:"url"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Name     string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	SSLMode  string `yaml:"sslmode"`
+	// QueryTimeout is the time a repository call gets to run its queries
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+}
+
+// SessionSettings choose where the sessions are kept. The memory store loses them on restart and is not
+// shared between instances, expired sessions are removed from Postgres every CleanupInterval
+type SessionSettings struct {
+	Store           string        `yaml:"store"`
+	Lifetime        time.Duration `yaml:"lifetime"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+}
+
+// MailSettings choose how mails are sent, the file transport writes them to Dir instead
+type MailSettings struct {
+	Transport    string `yaml:"transport"`
+	Dir          string `yaml:"dir"`
+	From         string `yaml:"from"`
+	OwnerEmail   string `yaml:"owner_email"`
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+}
+
+// PaymentSettings choose the payment provider, unpaid reservations are cancelled after Timeout.
+// The provider none takes no payment online, reservations are left new for staff to confirm
+type PaymentSettings struct {
+	Provider      string        `yaml:"provider"`
+	WebhookSecret string        `yaml:"webhook_secret"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+// DefaultSettings are the settings of a development machine
+func DefaultSettings() Settings {
+	return Settings{
+		Env:             EnvDevelopment,
+		Addr:            ":8080",
+		ShutdownTimeout: 30 * time.Second,
+		Database: DatabaseSettings{
+			Host:         "localhost",
+			Port:         5432,
+			User:         "admin",
+			SSLMode:      "disable",
+			QueryTimeout: 3 * time.Second,
+		},
+		Session: SessionSettings{
+			Store:           SessionStorePostgres,
+			Lifetime:        24 * time.Hour,
+			CleanupInterval: 5 * time.Minute,
+		},
+		Mail: MailSettings{
+			Transport:  "file",
+			Dir:        "./tmp/mail",
+			From:       "Fort Smythe <no-reply@fortsmythe.local>",
+			OwnerEmail: "owner@fortsmythe.local",
+			SMTPPort:   25,
+		},
+		Payments: PaymentSettings{
+			Provider:      fakePaymentProvider,
+			WebhookSecret: fakeWebhookSecret,
+			Timeout:       30 * time.Minute,
+		},
+		CalendarSyncInterval: 30 * time.Minute,
+		BookingHold:          15 * time.Minute,
+	}
+}
+
+// InProduction reports whether the application runs in production
+func (s Settings) InProduction() bool {
+	return s.Env == EnvProduction
+}
+
+// TemplateCache reports whether the templates are parsed once, at start
+func (s Settings) TemplateCache() bool {
+	if s.UseCache != nil {
+		return *s.UseCache
+	}
+	return s.InProduction()
+}
+
+// DSN is the connection string of the database
+func (d DatabaseSettings) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+
+	params := []struct{ key, value string }{
+		{"host", d.Host},
+		{"port", strconv.Itoa(d.Port)},
+		{"dbname", d.Name},
+		{"user", d.User},
+		{"password", d.Password},
+		{"sslmode", d.SSLMode},
+	}
+
+	var parts []string
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		// values are quoted so a password may hold spaces and quotes
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p.value)
+		parts = append(parts, fmt.Sprintf("%s='%s'", p.key, value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Load reads the settings from the flags in args, the environment read with getenv and the settings file.
+// The file is the one named by -config or CONFIG_FILE, which must exist, or DefaultSettingsFile when there is one
+func Load(args []string, getenv func(string) string) (Settings, error) {
+	s := DefaultSettings()
+
+	fl := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	file := fl.String("config", "", "path of the YAML settings file, "+DefaultSettingsFile+" when it exists")
+	env := fl.String("env", "", "environment: development, test or production")
+	addr := fl.String("addr", "", "address to listen on, such as :8080")
+	baseURL := fl.String("base-url", "", "public address of the site, used for links in mails")
+	useCache := fl.Bool("cache", false, "parse the templates once at start, on in production")
+	shutdownTimeout := fl.Duration("shutdown-timeout", 0, "how long requests in flight get to finish when stopping")
+	dbURL := fl.String("db-url", "", "database URL, instead of the other -db flags")
+	dbHost := fl.String("db-host", "", "database host")
+	dbPort := fl.Int("db-port", 0, "database port")
+	dbName := fl.String("db-name", "", "database name")
+	dbUser := fl.String("db-user", "", "database user")
+	if err := fl.Parse(args); err != nil {
+		return s, err
+	}
+	if fl.NArg() > 0 {
+		return s, fmt.Errorf("unexpected arguments: %s", strings.Join(fl.Args(), " "))
+	}
+
+	path, required := *file, true
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		path, required = DefaultSettingsFile, false
+	}
+	if err := s.readFile(path); err != nil && (required || !errors.Is(err, fs.ErrNotExist)) {
+		return s, err
+	}
+
+	if err := s.readEnv(getenv); err != nil {
+		return s, err
+	}
+
+	// only the flags given on the command line override the file and the environment
+	fl.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			s.Env = *env
+		case "addr":
+			s.Addr = *addr
+		case "base-url":
+			s.BaseURL = *baseURL
+		case "cache":
+			s.UseCache = useCache
+		case "shutdown-timeout":
+			s.ShutdownTimeout = *shutdownTimeout
+		case "db-url":
+			s.Database.URL = *dbURL
+		case "db-host":
+			s.Database.Host = *dbHost
+		case "db-port":
+			s.Database.Port = *dbPort
+		case "db-name":
+			s.Database.Name = *dbName
+		case "db-user":
+			s.Database.User = *dbUser
+		}
+	})
+
+	if s.BaseURL == "" && strings.HasPrefix(s.Addr, ":") {
+		s.BaseURL = "http://localhost" + s.Addr
+	}
+
+	return s, s.Validate()
+}
+
+// readFile reads the YAML settings file at path over s, unknown keys are an error so typos do not go unnoticed
+func (s *Settings) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.UnmarshalStrict(data, s)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// readEnv reads the environment variables that are set over s
+func (s *Settings) readEnv(getenv func(string) string) error {
+	str := func(v *string) func(string) error {
+		return func(value string) error {
+			*v = value
+			return nil
+		}
+	}
+	integer := func(v *int) func(string) error {
+		return func(value string) (err error) {
+			*v, err = strconv.Atoi(value)
+			return err
+		}
+	}
+	duration := func(v *time.Duration) func(string) error {
+		return func(value string) (err error) {
+			*v, err = time.ParseDuration(value)
+			return err
+		}
+	}
+
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"APP_ENV", str(&s.Env)},
+		{"HTTP_ADDR", str(&s.Addr)},
+		{"BASE_URL", str(&s.BaseURL)},
+		{"SHUTDOWN_TIMEOUT", duration(&s.ShutdownTimeout)},
+		{"USE_TEMPLATE_CACHE", func(value string) error {
+			b, err := strconv.ParseBool(value)
+			s.UseCache = &b
+			return err
+		}},
+		{"DATABASE_URL", str(&s.Database.URL)},
+		{"DATABASE_HOST", str(&s.Database.Host)},
+		{"DATABASE_PORT", integer(&s.Database.Port)},
+		{"DATABASE_NAME", str(&s.Database.Name)},
+		{"DATABASE_USER", str(&s.Database.User)},
+		{"DATABASE_PASSWORD", str(&s.Database.Password)},
+		{"DATABASE_SSLMODE", str(&s.Database.SSLMode)},
+		{"DATABASE_QUERY_TIMEOUT", duration(&s.Database.QueryTimeout)},
+		{"SESSION_STORE", str(&s.Session.Store)},
+		{"SESSION_LIFETIME", duration(&s.Session.Lifetime)},
+		{"SESSION_CLEANUP_INTERVAL", duration(&s.Session.CleanupInterval)},
+		{"MAIL_TRANSPORT", str(&s.Mail.Transport)},
+		{"MAIL_DIR", str(&s.Mail.Dir)},
+		{"MAIL_FROM", str(&s.Mail.From)},
+		{"OWNER_EMAIL", str(&s.Mail.OwnerEmail)},
+		{"SMTP_HOST", str(&s.Mail.SMTPHost)},
+		{"SMTP_PORT", integer(&s.Mail.SMTPPort)},
+		{"SMTP_USERNAME", str(&s.Mail.SMTPUsername)},
+		{"SMTP_PASSWORD", str(&s.Mail.SMTPPassword)},
+		{"PAYMENT_PROVIDER", str(&s.Payments.Provider)},
+		{"PAYMENT_WEBHOOK_SECRET", str(&s.Payments.WebhookSecret)},
+		{"PAYMENT_TIMEOUT", duration(&s.Payments.Timeout)},
+		{"CALENDAR_SYNC_INTERVAL", duration(&s.CalendarSyncInterval)},
+		{"BOOKING_HOLD", duration(&s.BookingHold)},
+	}
+
+	for _, v := range vars {
+		value := getenv(v.name)
+		if value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("%s: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the settings are complete and make sense, it reports every problem at once
+func (s Settings) Validate() error {
+	var errs []error
+	invalid := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch s.Env {
+	case EnvDevelopment, EnvTest, EnvProduction:
+	default:
+		invalid("env must be %s, %s or %s, not %q", EnvDevelopment, EnvTest, EnvProduction, s.Env)
+	}
+
+	if s.Addr == "" {
+		invalid("addr is required")
+	}
+	if s.ShutdownTimeout <= 0 {
+		invalid("shutdown_timeout must be positive")
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalid("base_url must be an http(s) address, not %q", s.BaseURL)
+	}
+
+	if s.Database.URL == "" {
+		if s.Database.Host == "" {
+			invalid("database host is required")
+		}
+		if s.Database.Port < 1 || s.Database.Port > 65535 {
+			invalid("database port %d is out of range", s.Database.Port)
+		}
+		if s.Database.Name == "" {
+			invalid("database name is required")
+		}
+		if s.Database.User == "" {
+			invalid("database user is required")
+		}
+	}
+	if s.Database.QueryTimeout <= 0 {
+		invalid("database query_timeout must be positive")
+	}
+
+	switch s.Session.Store {
+	case SessionStoreMemory, SessionStorePostgres:
+	default:
+		invalid("session store must be %s or %s, not %q", SessionStoreMemory, SessionStorePostgres, s.Session.Store)
+	}
+	if s.Session.Lifetime <= 0 {
+		invalid("session lifetime must be positive")
+	}
+	if s.Session.CleanupInterval <= 0 {
+		invalid("session cleanup_interval must be positive")
+	}
+
+	switch s.Mail.Transport {
+	case "file":
+		if s.Mail.Dir == "" {
+			invalid("mail dir is required by the file transport")
+		}
+	case "smtp":
+		if s.Mail.SMTPHost == "" {
+			invalid("smtp_host is required by the smtp transport")
+		}
+	default:
+		invalid("mail transport must be file or smtp, not %q", s.Mail.Transport)
+	}
+	if s.Mail.From == "" {
+		invalid("mail from is required")
+	}
+
+	switch s.Payments.Provider {
+	case fakePaymentProvider:
+		if s.InProduction() {
+			invalid("payment provider %s takes no money, it can not be used in production, %s takes no payment online", fakePaymentProvider, noPaymentProvider)
+		}
+	case noPaymentProvider:
+	default:
+		invalid("payment provider must be %s or %s, not %q", fakePaymentProvider, noPaymentProvider, s.Payments.Provider)
+	}
+	// the webhook secret checks the requests of the provider, there is none to check without a provider
+	if s.Payments.Provider != noPaymentProvider {
+		if strings.TrimSpace(s.Payments.WebhookSecret) == "" {
+			invalid("payment webhook_secret is required")
+		} else if s.InProduction() && s.Payments.WebhookSecret == fakeWebhookSecret {
+			invalid("payment webhook_secret must be a secret of your own in production, not the default one")
+		}
+	}
+	if s.Payments.Timeout <= 0 {
+		invalid("payment timeout must be positive")
+	}
+	if s.CalendarSyncInterval <= 0 {
+		invalid("calendar_sync_interval must be positive")
+	}
+	if s.BookingHold <= 0 {
+		invalid("booking_hold must be positive")
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv reading vars
+func env(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+func writeSettingsFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "bookings.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeSettingsFile(t, `
+env: production
+addr: ":9000"
+base_url: https://fortsmythe.example
+database:
+  host: db.internal
+  database: bookings
+  user: bookings
+  password: from-file
+payments:
+  provider: none
+  timeout: 15m
+session:
+  store: memory
+`)
+
+	s, err := Load([]string{"-config", path, "-db-host", "db.flag"}, env(map[string]string{
+		"DATABASE_PASSWORD":      "from-env",
+		"DATABASE_HOST":          "db.env",
+		"CALENDAR_SYNC_INTERVAL": "5m",
+		"BOOKING_HOLD":           "10m",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.InProduction() || !s.TemplateCache() {
+		t.Error("the file sets the production environment, with the template cache")
+	}
+	if s.Addr != ":9000" || s.BaseURL != "https://fortsmythe.example" {
+		t.Errorf("the address comes from the file, got %q and %q", s.Addr, s.BaseURL)
+	}
+	if s.Database.Password != "from-env" {
+		t.Errorf("the environment overrides the file, got password %q", s.Database.Password)
+	}
+	if s.Database.Host != "db.flag" {
+		t.Errorf("the flags override the environment, got host %q", s.Database.Host)
+	}
+	if s.Database.Port != 5432 || s.Mail.Transport != "file" {
+		t.Error("the defaults fill in what the file leaves out")
+	}
+	if s.Session.Store != SessionStoreMemory || s.Session.Lifetime != 24*time.Hour {
+		t.Errorf("the session store comes from the file, the lifetime from the defaults, got %q and %s", s.Session.Store, s.Session.Lifetime)
+	}
+	if s.Payments.Timeout != 15*time.Minute || s.CalendarSyncInterval != 5*time.Minute || s.BookingHold != 10*time.Minute {
+		t.Errorf("durations were not read, got %s, %s and %s", s.Payments.Timeout, s.CalendarSyncInterval, s.BookingHold)
+	}
+}
+
+func TestLoad_WithoutFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	s, err := Load(nil, env(map[string]string{"DATABASE_NAME": "bookings"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.InProduction() || s.TemplateCache() {
+		t.Error("the default environment is development, without the template cache")
+	}
+	if s.BaseURL != "http://localhost:8080" {
+		t.Errorf("the base URL defaults to the local address, got %q", s.BaseURL)
+	}
+
+	s, err = Load([]string{"-addr", ":9090", "-cache"}, env(map[string]string{"DATABASE_NAME": "bookings"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BaseURL != "http://localhost:9090" || !s.TemplateCache() {
+		t.Errorf("flags were not applied: %q, cache %v", s.BaseURL, s.TemplateCache())
+	}
+}
+
+func TestLoad_Production(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	s, err := Load([]string{"-env", "production"}, env(map[string]string{
+		"DATABASE_NAME":    "bookings",
+		"PAYMENT_PROVIDER": "none",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.InProduction() {
+		t.Error("the flag sets the production environment")
+	}
+	if err := s.Validate(); err != nil {
+		t.Errorf("production without online payments is valid, got %v", err)
+	}
+}
+
+var loadErrorTests = []struct {
+	name     string
+	args     []string
+	vars     map[string]string
+	file     string
+	expected string
+}{
+	{"missing database name", nil, nil, "", "database name is required"},
+	{"unknown environment", []string{"-env", "staging"}, map[string]string{"DATABASE_NAME": "b"}, "", `not "staging"`},
+	{"missing given file", []string{"-config", "missing.yml"}, nil, "", "missing.yml"},
+	{"missing file in the environment", nil, map[string]string{"CONFIG_FILE": "missing.yml"}, "", "missing.yml"},
+	{"typo in the file", nil, nil, "databse:\n  database: b\n", "databse"},
+	{"malformed duration", nil, map[string]string{"DATABASE_NAME": "b", "PAYMENT_TIMEOUT": "soon"}, "", "PAYMENT_TIMEOUT"},
+	{"malformed port", nil, map[string]string{"DATABASE_NAME": "b", "DATABASE_PORT": "x"}, "", "DATABASE_PORT"},
+	{"smtp without host", nil, map[string]string{"DATABASE_NAME": "b", "MAIL_TRANSPORT": "smtp"}, "", "smtp_host is required"},
+	{"relative base URL", []string{"-base-url", "fortsmythe.example"}, map[string]string{"DATABASE_NAME": "b"}, "", "base_url"},
+	{"no time to drain", []string{"-shutdown-timeout", "0s"}, map[string]string{"DATABASE_NAME": "b"}, "", "shutdown_timeout"},
+	{"unknown session store", nil, map[string]string{"DATABASE_NAME": "b", "SESSION_STORE": "redis"}, "", `not "redis"`},
+	{"no time to query", nil, map[string]string{"DATABASE_NAME": "b", "DATABASE_QUERY_TIMEOUT": "0s"}, "", "query_timeout"},
+	{"unknown flag", []string{"-port", "80"}, nil, "", "port"},
+	{"fake payments in production", []string{"-env", "production"}, map[string]string{"DATABASE_NAME": "b", "PAYMENT_WEBHOOK_SECRET": "whsec_1"}, "", "payment provider fake"},
+	{"default webhook secret in production", []string{"-env", "production"}, map[string]string{"DATABASE_NAME": "b"}, "", "webhook_secret must be a secret of your own"},
+	{"no webhook secret", nil, map[string]string{"DATABASE_NAME": "b", "PAYMENT_WEBHOOK_SECRET": " "}, "", "webhook_secret is required"},
+	{"unknown payment provider", nil, map[string]string{"DATABASE_NAME": "b", "PAYMENT_PROVIDER": "stripe"}, "", `not "stripe"`},
+}
+
+func TestLoad_Errors(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, e := range loadErrorTests {
+		args := e.args
+		if e.file != "" {
+			args = append([]string{"-config", writeSettingsFile(t, e.file)}, args...)
+		}
+
+		_, err := Load(args, env(e.vars))
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), e.expected) {
+			t.Errorf("%s: expected %q in the error, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	_, err := Load(nil, env(map[string]string{"DATABASE_USER": "", "MAIL_TRANSPORT": "pigeon", "CALENDAR_SYNC_INTERVAL": "-1m"}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, problem := range []string{"database name", "mail transport", "calendar_sync_interval"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("%q is not reported in %v", problem, err)
+		}
+	}
+}
+
+func TestDatabaseSettings_DSN(t *testing.T) {
+	var tests = []struct {
+		name     string
+		db       DatabaseSettings
+		expected string
+	}{
+		{"fields", DatabaseSettings{Host: "localhost", Port: 5432, Name: "bookings", User: "admin", Password: "secret", SSLMode: "disable"},
+			"host='localhost' port='5432' dbname='bookings' user='admin' password='secret' sslmode='disable'"},
+		{"quoted password", DatabaseSettings{Host: "db", Port: 5432, Name: "b", User: "u", Password: `it's a \ pass`},
+			`host='db' port='5432' dbname='b' user='u' password='it\'s a \\ pass'`},
+		{"url", DatabaseSettings{URL: "postgres://u:p@db/b", Host: "ignored"}, "postgres://u:p@db/b"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.db.DSN(); got != tt.expected {
+			t.Errorf("%s: got %s, expected %s", tt.name, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// shutdownHook is one step of the shutdown, see Shutdown.OnShutdown
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Shutdown holds what has to be stopped when the application exits: background workers, queues and the
+// database pool. Hooks run once the web server stopped taking requests, in the reverse order of registration
+// like deferred calls, so a worker registered after the database pool is stopped before the pool is closed
+type Shutdown struct {
+	mu    sync.Mutex
+	hooks []shutdownHook
+	done  bool
+}
+
+// OnShutdown registers fn to run at shutdown, name identifies it in errors. fn must return once ctx is done,
+// the drain timeout is over then
+func (s *Shutdown) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// Run runs every hook, even when some fail, and returns all their errors. Only the first call runs them
+func (s *Shutdown) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return nil
+	}
+	s.done = true
+	hooks := s.hooks
+	s.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", hooks[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown_Run(t *testing.T) {
+	var s Shutdown
+	var order []string
+
+	s.OnShutdown("database", func(ctx context.Context) error {
+		order = append(order, "database")
+		return nil
+	})
+	s.OnShutdown("mailer", func(ctx context.Context) error {
+		order = append(order, "mailer")
+		return errors.New("queue not drained")
+	})
+	s.OnShutdown("worker", func(ctx context.Context) error {
+		order = append(order, "worker")
+		return nil
+	})
+
+	err := s.Run(context.Background())
+	if strings.Join(order, ",") != "worker,mailer,database" {
+		t.Errorf("hooks ran in the order %v, expected the reverse of their registration", order)
+	}
+	if err == nil || !strings.Contains(err.Error(), "mailer: queue not drained") {
+		t.Errorf("expected the error of the mailer, got %v", err)
+	}
+
+	if err := s.Run(context.Background()); err != nil || len(order) != 3 {
+		t.Error("hooks must only run once")
+	}
+}
+
+func TestShutdown_RunTimesOut(t *testing.T) {
+	var s Shutdown
+	s.OnShutdown("stuck worker", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := s.Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be reported, got %v", err)
+	}
+}
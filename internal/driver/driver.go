@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -41,6 +42,11 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbConn, nil
 }
 
+// Ping checks that the database answers before ctx is done
+func (d *DB) Ping(ctx context.Context) error {
+	return d.SQL.PingContext(ctx)
+}
+
 // testDB pings the database to make sure we have a connection pool established
 func testDB(d *sql.DB) (error) {
 	err := d.Ping()
@@ -3,7 +3,11 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -68,4 +72,150 @@ func (f *Form) IsEmail(field string) bool {
 		return false
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// DateLayout is the format of every date field, the one sent by the date pickers
+const DateLayout = "2006-01-02"
+
+// IsDate checks if a field contains a date formatted as YYYY-MM-DD. If not, an error message is added to the form's Errors map.
+func (f *Form) IsDate(field string) bool {
+	_, err := time.Parse(DateLayout, f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "This field must be a date formatted as YYYY-MM-DD")
+		return false
+	}
+	return true
+}
+
+// IsInt checks if a field contains a whole number. If not, an error message is added to the form's Errors map.
+func (f *Form) IsInt(field string) bool {
+	_, err := strconv.Atoi(f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "This field must be a whole number")
+		return false
+	}
+	return true
+}
+
+// IsOneOf checks if a field holds one of values. If not, an error message is added to the form's Errors map.
+func (f *Form) IsOneOf(field string, values ...string) bool {
+	if !slices.Contains(values, f.Get(field)) {
+		f.Errors.Add(field, "This is not one of the values allowed")
+		return false
+	}
+	return true
+}
+
+// IntBetween checks if a field contains a whole number from min to max. If not, an error message is added to the form's Errors map.
+func (f *Form) IntBetween(field string, min, max int) bool {
+	if !f.IsInt(field) {
+		return false
+	}
+	if i := f.Int(field); i < min || i > max {
+		f.Errors.Add(field, fmt.Sprintf("This field must be between %d and %d", min, max))
+		return false
+	}
+	return true
+}
+
+// DateRange checks that start and end are dates, that the stay starts today or later,
+// ends after it starts and lasts at most maxNights nights. Errors are added to the field at fault.
+func (f *Form) DateRange(start, end string, maxNights int) bool {
+	// report a malformed date once, and only compare two valid dates
+	if !f.IsDate(start) || !f.IsDate(end) {
+		return false
+	}
+
+	startDate := f.Date(start)
+	endDate := f.Date(end)
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if startDate.Before(today) {
+		f.Errors.Add(start, "This date can not be in the past")
+		return false
+	}
+
+	if !endDate.After(startDate) {
+		f.Errors.Add(end, "This date must be after the arrival date")
+		return false
+	}
+
+	if nights := int(endDate.Sub(startDate).Hours() / 24); nights > maxNights {
+		f.Errors.Add(end, fmt.Sprintf("A stay can not be longer than %d nights", maxNights))
+		return false
+	}
+
+	return true
+}
+
+// Date returns the value of a date field, the zero time when it is not a valid date
+func (f *Form) Date(field string) time.Time {
+	t, _ := time.Parse(DateLayout, f.Get(field))
+	return t
+}
+
+// Int returns the value of an integer field, 0 when it is not a whole number
+func (f *Form) Int(field string) int {
+	i, _ := strconv.Atoi(f.Get(field))
+	return i
+}
+
+// slugPattern matches lowercase words joined by dashes, e.g. generals-quarters
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// IsSlug checks if a field can be used in a URL path, lowercase letters, digits and dashes. If not, an error message is added to the form's Errors map.
+func (f *Form) IsSlug(field string) bool {
+	if !slugPattern.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "This field may only contain lowercase letters, digits and dashes")
+		return false
+	}
+	return true
+}
+
+// amountPattern matches a non negative amount of money with at most two decimals, e.g. 120 or 99.50
+var amountPattern = regexp.MustCompile(`^\d+(\.\d{1,2})?$`)
+
+// IsAmount checks if a field contains an amount of money such as 99.50. If not, an error message is added to the form's Errors map.
+func (f *Form) IsAmount(field string) bool {
+	if !amountPattern.MatchString(strings.TrimSpace(f.Get(field))) {
+		f.Errors.Add(field, "This field must be an amount such as 99.50")
+		return false
+	}
+	return true
+}
+
+// Cents returns the value of an amount field in cents, 0 when it is not a valid amount
+func (f *Form) Cents(field string) int {
+	value := strings.TrimSpace(f.Get(field))
+	if !amountPattern.MatchString(value) {
+		return 0
+	}
+
+	whole, fraction, _ := strings.Cut(value, ".")
+	units, _ := strconv.Atoi(whole)
+	cents, _ := strconv.Atoi((fraction + "00")[:2])
+	return units*100 + cents
+}
+
+// promoCodePattern matches a promo code once uppercased, e.g. SUMMER-10
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]+)*$`)
+
+// IsPromoCode checks if an optional field holds a well formed promo code of at most 32 characters, case does not matter.
+// If not, an error message is added to the form's Errors map.
+func (f *Form) IsPromoCode(field string) bool {
+	code := f.PromoCode(field)
+	if code == "" {
+		return true
+	}
+	if len(code) > 32 || !promoCodePattern.MatchString(code) {
+		f.Errors.Add(field, "This is not a valid promo code")
+		return false
+	}
+	return true
+}
+
+// PromoCode returns the value of a promo code field trimmed and uppercased
+func (f *Form) PromoCode(field string) string {
+	return strings.ToUpper(strings.TrimSpace(f.Get(field)))
+}
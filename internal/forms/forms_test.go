@@ -3,6 +3,7 @@ package forms
 import (
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -134,4 +135,156 @@ func TestForm_IsEmail(t *testing.T) {
 	if newForm_invalid.Errors.Get("email") == "" {
 		t.Error("got no error for email when there should be one")
 	}
-}
\ No newline at end of file
+}
+func TestForm_IsDate(t *testing.T) {
+	data := url.Values{}
+	data.Add("start", "2050-01-01")
+	data.Add("end", "01/02/2050")
+
+	newForm := New(data)
+
+	if !newForm.IsDate("start") {
+		t.Error("got an invalid date when it should be valid")
+	}
+	if newForm.IsDate("end") {
+		t.Error("got a valid date when it should be invalid")
+	}
+	if newForm.Errors.Get("end") == "" {
+		t.Error("got no error for end when there should be one")
+	}
+	if newForm.Date("start").Year() != 2050 || !newForm.Date("end").IsZero() {
+		t.Error("Date returned the wrong value")
+	}
+}
+
+func TestForm_IsInt(t *testing.T) {
+	data := url.Values{}
+	data.Add("id", "12")
+	data.Add("other", "12a")
+
+	newForm := New(data)
+
+	if !newForm.IsInt("id") || newForm.Int("id") != 12 {
+		t.Error("got an invalid integer when it should be valid")
+	}
+	if newForm.IsInt("other") || newForm.Int("other") != 0 {
+		t.Error("got a valid integer when it should be invalid")
+	}
+	if newForm.Errors.Get("other") == "" {
+		t.Error("got no error for other when there should be one")
+	}
+}
+
+func TestForm_IsOneOf(t *testing.T) {
+	data := url.Values{}
+	data.Add("status", "new")
+	data.Add("other", "old")
+
+	newForm := New(data)
+
+	if !newForm.IsOneOf("status", "new", "confirmed") {
+		t.Error("got a value not allowed when it should be allowed")
+	}
+	if newForm.IsOneOf("other", "new", "confirmed") {
+		t.Error("got an allowed value when it should not be allowed")
+	}
+	if newForm.Errors.Get("other") == "" {
+		t.Error("got no error for other when there should be one")
+	}
+}
+
+func TestForm_IntBetween(t *testing.T) {
+	for value, valid := range map[string]bool{"0": true, "2": true, "12": true, "13": false, "-1": false, "two": false, "": false} {
+		data := url.Values{}
+		data.Add("adults", value)
+
+		newForm := New(data)
+
+		if newForm.IntBetween("adults", 0, 12) != valid {
+			t.Errorf("IntBetween(%q) returned the wrong result", value)
+		}
+		if !valid && newForm.Errors.Get("adults") == "" {
+			t.Errorf("got no error for %q when there should be one", value)
+		}
+	}
+}
+
+var dateRangeTests = []struct {
+	name         string
+	start        string
+	end          string
+	valid        bool
+	errorOnStart bool
+}{
+	{"valid", "2050-01-01", "2050-01-08", true, false},
+	{"malformed start", "tomorrow", "2050-01-08", false, true},
+	{"malformed end", "2050-01-01", "", false, false},
+	{"end before start", "2050-01-08", "2050-01-01", false, false},
+	{"same day", "2050-01-01", "2050-01-01", false, false},
+	{"in the past", "2020-01-01", "2020-01-03", false, true},
+	{"too long", "2050-01-01", "2050-02-01", false, false},
+}
+
+func TestForm_DateRange(t *testing.T) {
+	for _, e := range dateRangeTests {
+		data := url.Values{}
+		data.Add("start", e.start)
+		data.Add("end", e.end)
+
+		newForm := New(data)
+
+		if newForm.DateRange("start", "end", 14) != e.valid {
+			t.Errorf("%s: expected valid to be %v", e.name, e.valid)
+		}
+		if newForm.Valid() != e.valid {
+			t.Errorf("%s: the errors do not match the result: %v", e.name, newForm.Errors)
+		}
+		if !e.valid && (newForm.Errors.Get("start") != "") != e.errorOnStart {
+			t.Errorf("%s: got the error on the wrong field: %v", e.name, newForm.Errors)
+		}
+	}
+}
+
+func TestForm_IsSlug(t *testing.T) {
+	data := url.Values{}
+	data.Add("good", "generals-quarters-2")
+	data.Add("bad", "General's Quarters")
+
+	newForm := New(data)
+
+	if !newForm.IsSlug("good") {
+		t.Error("got an invalid slug when it should be valid")
+	}
+	if newForm.IsSlug("bad") {
+		t.Error("got a valid slug when it should be invalid")
+	}
+}
+
+func TestForm_Cents(t *testing.T) {
+	for value, cents := range map[string]int{"120": 12000, "99.5": 9950, "0.05": 5, "12.345": 0, "-1": 0, "": 0} {
+		data := url.Values{}
+		data.Add("price", value)
+
+		newForm := New(data)
+
+		if newForm.IsAmount("price") != (cents > 0) {
+			t.Errorf("IsAmount(%q) returned the wrong result", value)
+		}
+		if got := newForm.Cents("price"); got != cents {
+			t.Errorf("Cents(%q) = %d, wanted %d", value, got, cents)
+		}
+	}
+}
+
+func TestForm_IsPromoCode(t *testing.T) {
+	for value, valid := range map[string]bool{"": true, "SUMMER10": true, " summer-10 ": true, "10% OFF": false, "-SUMMER": false, strings.Repeat("A", 33): false} {
+		data := url.Values{}
+		data.Add("promo_code", value)
+
+		newForm := New(data)
+
+		if newForm.IsPromoCode("promo_code") != valid {
+			t.Errorf("IsPromoCode(%q) returned the wrong result", value)
+		}
+	}
+}
@@ -0,0 +1,817 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bangn/bookings/internal/forms"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/render"
+	"github.com/bangn/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// AdminDashboard renders the landing page of the admin area
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+}
+
+// AdminNewReservations lists the reservations waiting for staff to confirm them
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.listReservations(w, r, "admin-new-reservations.page.tmpl", nil, m.DB.AllNewReservations)
+}
+
+// AdminAllReservations lists every reservation in the system
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.listReservations(w, r, "admin-all-reservations.page.tmpl", models.ReservationStatuses, m.DB.AllReservations)
+}
+
+// listReservations renders a reservation list page with the reservations list returns for the filters of the
+// query string: status, one of statuses, the from and to dates of the stays, room and guest, a part of the name
+// or email. Nothing is listed while a filter is malformed, the page tells which one
+func (m *Repository) listReservations(w http.ResponseWriter, r *http.Request, tmpl string, statuses []string,
+	list func(context.Context, models.ReservationFilter) ([]models.Reservation, error)) {
+	form := forms.New(r.URL.Query())
+	if form.Get("status") != "" {
+		form.IsOneOf("status", statuses...)
+	}
+	for _, field := range []string{"from", "to"} {
+		if form.Get(field) != "" {
+			form.IsDate(field)
+		}
+	}
+	if form.Get("room") != "" {
+		form.IsInt("room")
+	}
+	if form.Valid() && form.Get("from") != "" && form.Get("to") != "" && form.Date("to").Before(form.Date("from")) {
+		form.Errors.Add("to", "This date can not be before the from date")
+	}
+
+	var reservations []models.Reservation
+	if form.Valid() {
+		var err error
+		reservations, err = list(r.Context(), models.ReservationFilter{
+			Status: form.Get("status"),
+			From:   form.Date("from"),
+			To:     form.Date("to"),
+			RoomID: form.Int("room"),
+			Guest:  strings.TrimSpace(form.Get("guest")),
+		})
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["rooms"] = rooms
+	data["statuses"] = statuses
+
+	render.Template(w, r, tmpl, &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminShowReservation displays one reservation,
+// src is the list ("new" or "all") the user came from, so we can link back to it
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't find reservation")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+		return
+	}
+
+	events, err := m.DB.GetReservationEvents(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["events"] = events
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminProcessReservation confirms a new reservation, which takes it off the new reservations list,
+// and goes back to the list it was opened from
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.UpdateReservationStatus(r.Context(), id, models.ReservationConfirmed, m.staffActor(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", "Only new reservations can be processed")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// staffActor is the logged in member of staff, for the history of reservations
+func (m *Repository) staffActor(r *http.Request) models.Actor {
+	return models.Actor{Name: "staff", UserID: m.App.Session.GetInt(r.Context(), "user_id")}
+}
+
+// AdminPostReservationStatus moves a reservation to the status posted, e.g. checks the guest in
+func (m *Repository) AdminPostReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	link := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+
+	// refunds go through AdminRefundReservation, which pays the guest back
+	status := r.Form.Get("status")
+	if status == models.ReservationRefunded {
+		m.App.Session.Put(r.Context(), "error", "Use the refund button to refund a reservation")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateReservationStatus(r.Context(), id, status, m.staffActor(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The reservation can not be moved to %q", status))
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation status changed")
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// AdminRefundReservation pays a reservation back and cancels it
+func (m *Repository) AdminRefundReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	link := fmt.Sprintf("/admin/reservations/%s/%d", src, id)
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if res.Status != models.ReservationConfirmed || res.PaymentIntentID == "" {
+		m.App.Session.Put(r.Context(), "error", "This reservation has no payment to refund")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	err = m.refundPayment(r.Context(), res, m.staffActor(r))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation refunded and cancelled")
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// calendarDay is one night of one room in the reservations calendar
+type calendarDay struct {
+	Date          string
+	Day           int
+	ReservationID int
+	BlockID       int
+	// Imported is set when the block mirrors a booking on another platform, it is managed by its calendar feed
+	Imported bool
+	// Held is set while a guest who is booking holds the night, the hold ends with their booking
+	Held bool
+}
+
+// calendarRow is the line of one room in the reservations calendar
+type calendarRow struct {
+	Room models.Room
+	Days []calendarDay
+}
+
+// calendarMonth returns the first day of the month asked for with the y and m parameters,
+// the current month when they are missing
+func calendarMonth(r *http.Request) (time.Time, error) {
+	now := time.Now()
+	if r.Form.Get("y") == "" && r.Form.Get("m") == "" {
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	year, err := strconv.Atoi(r.Form.Get("y"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	month, err := strconv.Atoi(r.Form.Get("m"))
+	if err != nil || month < 1 || month > 12 {
+		return time.Time{}, errors.New("invalid month")
+	}
+
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// buildCalendar reads the restrictions of every room for the month starting at first
+func (m *Repository) buildCalendar(ctx context.Context, first time.Time) ([]calendarRow, error) {
+	next := first.AddDate(0, 1, 0)
+
+	rooms, err := m.DB.AllRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []calendarRow
+	for _, room := range rooms {
+		row := calendarRow{Room: room}
+		for d := first; d.Before(next); d = d.AddDate(0, 0, 1) {
+			row.Days = append(row.Days, calendarDay{
+				Date: d.Format("2006-01-02"),
+				Day:  d.Day(),
+			})
+		}
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(ctx, room.ID, first, next)
+		if err != nil {
+			return nil, err
+		}
+
+		// a restriction covers the nights from its start date up to, not including, its end date
+		for _, rr := range restrictions {
+			for d := rr.StartDate; d.Before(rr.EndDate); d = d.AddDate(0, 0, 1) {
+				if d.Before(first) || !d.Before(next) {
+					continue
+				}
+				day := &row.Days[d.Day()-1]
+				switch {
+				case rr.RestrictionID == models.RestrictionReservation:
+					day.ReservationID = rr.ReservationID
+				case rr.IsHold():
+					day.Held = true
+				default:
+					day.BlockID = rr.ID
+					day.Imported = rr.IsImported()
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// AdminReservationsCalendar shows, for one month, which nights of every room are reserved or blocked
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	first, err := calendarMonth(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rows, err := m.buildCalendar(r.Context(), first)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	prev := first.AddDate(0, -1, 0)
+	next := first.AddDate(0, 1, 0)
+
+	stringMap := make(map[string]string)
+	stringMap["this_month"] = first.Format("January 2006")
+	stringMap["prev_link"] = fmt.Sprintf("?y=%d&m=%d", prev.Year(), prev.Month())
+	stringMap["next_link"] = fmt.Sprintf("?y=%d&m=%d", next.Year(), next.Month())
+
+	intMap := make(map[string]int)
+	intMap["year"] = first.Year()
+	intMap["month"] = int(first.Month())
+
+	data := make(map[string]interface{})
+	data["rows"] = rows
+	if len(rows) > 0 {
+		data["days"] = rows[0].Days
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminPostReservationsCalendar saves the owner blocks ticked in the calendar:
+// a checked night that is free gets blocked, an unchecked blocked night is released.
+// Imported blocks and held nights are not part of the form, they follow their calendar feed or booking
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	first, err := calendarMonth(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rows, err := m.buildCalendar(r.Context(), first)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	skipped := 0
+	for _, row := range rows {
+		for _, day := range row.Days {
+			checked := r.PostForm.Has(fmt.Sprintf("block_%d_%s", row.Room.ID, day.Date))
+
+			switch {
+			case day.Imported || day.Held:
+				continue
+
+			case day.BlockID > 0 && !checked:
+				// a block may span several nights, only the unchecked one is released
+				date, _ := time.Parse("2006-01-02", day.Date)
+				err = m.DB.DeleteBlockNight(r.Context(), day.BlockID, date)
+				if err != nil {
+					helpers.ServerError(w, r, err)
+					return
+				}
+
+			case day.BlockID == 0 && day.ReservationID == 0 && checked:
+				date, _ := time.Parse("2006-01-02", day.Date)
+				err = m.DB.InsertBlockForRoom(r.Context(), row.Room.ID, date)
+				if errors.Is(err, repository.ErrRoomNotAvailable) {
+					// booked by a guest since the page was loaded
+					skipped++
+					continue
+				} else if err != nil {
+					helpers.ServerError(w, r, err)
+					return
+				}
+			}
+		}
+	}
+
+	if skipped > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Changes saved, %d night(s) were booked in the meantime and could not be blocked", skipped))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", first.Year(), first.Month()), http.StatusSeeOther)
+}
+
+// apiKeyPrefix starts every API key, so leaked keys are easy to recognise
+const apiKeyPrefix = "bk_"
+
+// renderAPIKeys renders the API keys page with the create form
+func (m *Repository) renderAPIKeys(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	keys, err := m.DB.AllAPIKeys(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	// the plain key is only known right after it was created
+	stringMap["new_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	data := make(map[string]interface{})
+	data["api_keys"] = keys
+	data["users"] = users
+
+	render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminAPIKeys lists the API keys and shows the form to create one
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	m.renderAPIKeys(w, r, forms.New(nil))
+}
+
+// AdminPostAPIKey creates an API key for a user, the key itself is shown once and only its hash is stored
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "user_id", "scope")
+
+	userID, _ := strconv.Atoi(form.Get("user_id"))
+	user, err := m.DB.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("user_id", "Unknown user")
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	scope, _ := strconv.Atoi(form.Get("scope"))
+	switch {
+	case scope != models.AccessLevelUser && scope != models.AccessLevelAdmin:
+		form.Errors.Add("scope", "Invalid scope")
+	case user.ID != 0 && scope > user.AccessLevel:
+		form.Errors.Add("scope", "The scope can not be above the access level of the user")
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderAPIKeys(w, r, form)
+		return
+	}
+
+	token, err := helpers.RandomToken(32)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	plain := apiKeyPrefix + token
+
+	key := models.APIKey{
+		UserID: user.ID,
+		Name:   form.Get("name"),
+		Prefix: plain[:len(apiKeyPrefix)+6],
+		Scope:  scope,
+	}
+
+	_, err = m.DB.InsertAPIKey(r.Context(), key, helpers.HashToken(plain))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "new_api_key", plain)
+	m.App.Session.Put(r.Context(), "flash", "API key created")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey revokes an API key, requests made with it are refused from then on
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRooms lists the rooms of the catalogue
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderAdminRoom renders the room form, room.ID is 0 for a new room
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+	if room.ID != 0 {
+		data["calendar_url"] = m.roomCalendarURL(room)
+
+		feeds, err := m.DB.GetRoomCalendarFeeds(r.Context(), room.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["feeds"] = feeds
+	}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// roomFormValues fills the room form with the saved values of a room
+func roomFormValues(room models.Room) url.Values {
+	var images []string
+	for _, img := range room.Images {
+		images = append(images, img.Path)
+	}
+
+	return url.Values{
+		"room_name":     {room.RoomName},
+		"slug":          {room.Slug},
+		"description":   {room.Description},
+		"capacity":      {strconv.Itoa(room.Capacity)},
+		"nightly_price": {fmt.Sprintf("%d.%02d", room.NightlyPrice/100, room.NightlyPrice%100)},
+		"images":        {strings.Join(images, "\n")},
+	}
+}
+
+// roomFromForm validates the posted room form and returns the room it describes.
+// The slug must not be used by another room than the one with id
+func (m *Repository) roomFromForm(r *http.Request, id int) (models.Room, *forms.Form, error) {
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "capacity", "nightly_price")
+	form.IsSlug("slug")
+	if form.IsInt("capacity") && form.Int("capacity") < 1 {
+		form.Errors.Add("capacity", "A room sleeps at least one guest")
+	}
+	form.IsAmount("nightly_price")
+
+	room := models.Room{
+		ID:           id,
+		RoomName:     strings.TrimSpace(form.Get("room_name")),
+		Slug:         form.Get("slug"),
+		Description:  strings.TrimSpace(form.Get("description")),
+		Capacity:     form.Int("capacity"),
+		NightlyPrice: form.Cents("nightly_price"),
+	}
+
+	// one image path per line, served from /static/images or an https URL
+	for _, line := range strings.Split(form.Get("images"), "\n") {
+		path := strings.TrimSpace(line)
+		if path == "" {
+			continue
+		}
+		if !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "https://") {
+			form.Errors.Add("images", "Images must be paths such as /static/images/room.png or https URLs")
+			break
+		}
+		room.Images = append(room.Images, models.RoomImage{Path: path})
+	}
+
+	if form.Errors.Get("slug") == "" {
+		other, err := m.DB.GetRoomBySlug(r.Context(), room.Slug)
+		if err == nil && other.ID != id {
+			form.Errors.Add("slug", "This slug is already used by "+other.RoomName)
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return room, form, err
+		}
+	}
+
+	return room, form, nil
+}
+
+// AdminNewRoom shows the form to add a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminRoom(w, r, models.Room{}, forms.New(url.Values{"capacity": {"2"}}))
+}
+
+// AdminPostNewRoom adds a room to the catalogue
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, form, err := m.roomFromForm(r, 0)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderAdminRoom(w, r, models.Room{}, form)
+		return
+	}
+
+	room.CalendarToken, err = helpers.RandomToken(32)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room added")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// adminRoom loads the room named by the {id} URL parameter, it writes the error response and returns false on failure
+func (m *Repository) adminRoom(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// AdminShowRoom shows the form to edit a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderAdminRoom(w, r, room, forms.New(roomFormValues(room)))
+}
+
+// AdminPostRoom saves the changes made to a room
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	saved, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	room, form, err := m.roomFromForm(r, saved.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderAdminRoom(w, r, saved, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminResetRoomCalendarToken gives the calendar feed of a room a new address, for when the old one leaked
+func (m *Repository) AdminResetRoomCalendarToken(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := helpers.RandomToken(32)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.DB.SetRoomCalendarToken(r.Context(), room.ID, token)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "The calendar feed has a new address, calendars subscribed to the old one stop updating")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoom removes a room from the catalogue, unless it was ever booked
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteRoom(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomHasReservations) {
+		m.App.Session.Put(r.Context(), "error", "This room has reservations and can not be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
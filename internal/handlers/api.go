@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bangn/bookings/internal/forms"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/pricing"
+	"github.com/bangn/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// maxAPIBodyBytes limits the size of JSON request bodies
+const maxAPIBodyBytes = 1 << 20
+
+// contextKey is the type of the values handlers store in request contexts
+type contextKey string
+
+// apiKeyContextKey holds the models.APIKey a request was authenticated with
+const apiKeyContextKey contextKey = "api_key"
+
+// apiEnvelope wraps every API response, exactly one of Data or Error is set
+type apiEnvelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// apiError is the body of an API error, Fields holds per field validation messages
+type apiError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+// apiAvailability is the answer of the room availability endpoint
+type apiAvailability struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Available bool   `json:"available"`
+}
+
+// apiReservationRequest is the body of POST /api/v1/reservations
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	PromoCode string `json:"promo_code"`
+	// Adults defaults to 1 when left out
+	Adults   int `json:"adults"`
+	Children int `json:"children"`
+}
+
+// writeJSON writes data wrapped in the API envelope
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	writeEnvelope(w, status, apiEnvelope{Data: data})
+}
+
+// writeJSONError writes an API error envelope
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeEnvelope(w, status, apiEnvelope{Error: &apiError{Status: status, Message: message}})
+}
+
+// writeJSONServerError logs err like helpers.ServerError does, but answers with a JSON envelope
+func writeJSONServerError(w http.ResponseWriter, r *http.Request, err error) {
+	helpers.LogServerError(r, err)
+	writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, env apiEnvelope) {
+	out, err := json.Marshal(env)
+	if err != nil {
+		// only a bug lands here, the response types always encode
+		Repo.App.Logger.Error("encoding JSON", "error", err)
+		helpers.ClientError(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// APIAuth authenticates API requests with an "Authorization: Bearer <key>" header.
+// The scope of the request is the one of the key, capped by the current access level of its user
+func (m *Repository) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(key) == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		apiKey, err := m.DB.GetAPIKeyByHash(r.Context(), helpers.HashToken(strings.TrimSpace(key)))
+		if errors.Is(err, sql.ErrNoRows) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			writeJSONError(w, http.StatusUnauthorized, "invalid or revoked API key")
+			return
+		} else if err != nil {
+			writeJSONServerError(w, r, err)
+			return
+		}
+
+		apiKey.Scope = min(apiKey.Scope, apiKey.User.AccessLevel)
+
+		ctx := context.WithValue(r.Context(), apiKeyContextKey, apiKey)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// APIScope only lets through requests authenticated by APIAuth with at least the given scope
+func (m *Repository) APIScope(scope int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey, ok := r.Context().Value(apiKeyContextKey).(models.APIKey)
+			if !ok {
+				writeJSONError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			if apiKey.Scope < scope {
+				writeJSONError(w, http.StatusForbidden, "the API key does not have the required scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APINotFound answers unknown API routes
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "resource not found")
+}
+
+// APIMethodNotAllowed answers API routes called with the wrong method
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// APIRooms lists all rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	if rooms == nil {
+		rooms = []models.Room{}
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// apiRoom loads the room named by the {id} URL parameter, it writes the error response and returns false on failure
+func (m *Repository) apiRoom(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "room id must be a number")
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "room not found")
+		return room, false
+	} else if err != nil {
+		writeJSONServerError(w, r, err)
+		return room, false
+	}
+
+	return room, true
+}
+
+// APIRoomAvailability tells whether a room is free between the start and end query parameters
+func (m *Repository) APIRoomAvailability(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.URL.Query())
+	form.DateRange("start", "end", maxStayNights)
+	if !form.Valid() {
+		writeEnvelope(w, http.StatusBadRequest, apiEnvelope{Error: &apiError{
+			Status:  http.StatusBadRequest,
+			Message: "invalid query parameters",
+			Fields:  form.Errors,
+		}})
+		return
+	}
+
+	sd := form.Get("start")
+	ed := form.Get("end")
+	startDate := form.Date("start")
+	endDate := form.Date("end")
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomId(r.Context(), startDate, endDate, room.ID)
+	if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiAvailability{
+		RoomID:    room.ID,
+		StartDate: sd,
+		EndDate:   ed,
+		Available: available,
+	})
+}
+
+// APIRoomQuote prices a stay in a room between the start and end query parameters
+func (m *Repository) APIRoomQuote(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.apiRoom(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.URL.Query())
+	form.DateRange("start", "end", maxStayNights)
+	if !form.Valid() {
+		writeEnvelope(w, http.StatusBadRequest, apiEnvelope{Error: &apiError{
+			Status:  http.StatusBadRequest,
+			Message: "invalid query parameters",
+			Fields:  form.Errors,
+		}})
+		return
+	}
+
+	quote, err := m.quoteRoom(r.Context(), room, form.Date("start"), form.Date("end"))
+	var minStay *pricing.MinStayError
+	if errors.As(err, &minStay) {
+		writeJSONError(w, http.StatusUnprocessableEntity, minStay.Error())
+		return
+	} else if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, quote)
+}
+
+// APIPostReservation books a room from a JSON body
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	if req.Adults == 0 {
+		req.Adults = 1
+	}
+
+	// reuse the validation of the make reservation form
+	form := forms.New(url.Values{
+		"first_name": {req.FirstName},
+		"last_name":  {req.LastName},
+		"email":      {req.Email},
+		"start_date": {req.StartDate},
+		"end_date":   {req.EndDate},
+		"promo_code": {req.PromoCode},
+		"adults":     {strconv.Itoa(req.Adults)},
+		"children":   {strconv.Itoa(req.Children)},
+	})
+	form.Required("first_name", "last_name", "email", "start_date", "end_date")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	form.DateRange("start_date", "end_date", maxStayNights)
+	form.IsPromoCode("promo_code")
+	form.IntBetween("adults", 1, maxPartySize)
+	form.IntBetween("children", 0, maxPartySize)
+
+	if !form.Valid() {
+		writeEnvelope(w, http.StatusUnprocessableEntity, apiEnvelope{Error: &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "validation failed",
+			Fields:  form.Errors,
+		}})
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), req.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusUnprocessableEntity, "room not found")
+		return
+	} else if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	quote, err := m.quoteRoom(r.Context(), room, form.Date("start_date"), form.Date("end_date"))
+	var minStay *pricing.MinStayError
+	if errors.As(err, &minStay) {
+		form.Errors.Add("end_date", minStay.Error())
+		writeEnvelope(w, http.StatusUnprocessableEntity, apiEnvelope{Error: &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "validation failed",
+			Fields:  form.Errors,
+		}})
+		return
+	} else if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	var promoCodeID int
+	if form.PromoCode("promo_code") != "" {
+		promo, err := m.DB.GetPromoCodeByCode(r.Context(), form.PromoCode("promo_code"))
+		if errors.Is(err, sql.ErrNoRows) {
+			form.Errors.Add("promo_code", "This promo code does not exist")
+		} else if err != nil {
+			writeJSONServerError(w, r, err)
+			return
+		} else if quote, err = pricing.ApplyPromoCode(quote, promo, time.Now()); err != nil {
+			form.Errors.Add("promo_code", err.Error())
+		} else {
+			promoCodeID = promo.ID
+		}
+	}
+
+	if !form.Valid() {
+		writeEnvelope(w, http.StatusUnprocessableEntity, apiEnvelope{Error: &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "validation failed",
+			Fields:  form.Errors,
+		}})
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName:   req.FirstName,
+		LastName:    req.LastName,
+		Email:       req.Email,
+		Phone:       req.Phone,
+		StartDate:   form.Date("start_date"),
+		EndDate:     form.Date("end_date"),
+		RoomID:      room.ID,
+		Room:        room,
+		Total:       quote.Total,
+		Currency:    quote.Currency,
+		Discount:    quote.Discount,
+		PromoCodeID: promoCodeID,
+		Status:      m.bookingStatus(quote.Total),
+		Adults:      req.Adults,
+		Children:    req.Children,
+	}
+
+	reservation.Token, err = helpers.RandomToken(32)
+	if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	reservation.ID, err = m.DB.BookRoom(r.Context(), reservation)
+	countBooking("api", err)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		writeJSONError(w, http.StatusConflict, "room is not available for those dates")
+		return
+	} else if errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		writeJSONError(w, http.StatusConflict, pricing.ErrPromoCodeUsedUp.Error())
+		return
+	} else if errors.Is(err, repository.ErrOverCapacity) {
+		form.Errors.Add("adults", fmt.Sprintf("%s sleeps at most %d guests", room.RoomName, room.Capacity))
+		writeEnvelope(w, http.StatusUnprocessableEntity, apiEnvelope{Error: &apiError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "validation failed",
+			Fields:  form.Errors,
+		}})
+		return
+	} else if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	if reservation.IsPendingPayment() {
+		reservation.PaymentIntentID, err = m.paymentIntent(r.Context(), reservation)
+		if err != nil {
+			writeJSONServerError(w, r, err)
+			return
+		}
+	} else {
+		m.sendReservationMails(reservation)
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", reservation.ID))
+	writeJSON(w, http.StatusCreated, reservation)
+}
+
+// APIGetReservation returns one reservation, the route is limited to keys with the admin scope
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "reservation id must be a number")
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "reservation not found")
+		return
+	} else if err != nil {
+		writeJSONServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bangn/bookings/internal/pricing"
+)
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	key                string
+	body               string
+	expectedStatusCode int
+}{
+	{"rooms", "GET", "/api/v1/rooms", "test-user-key", "", http.StatusOK},
+	{"availability", "GET", "/api/v1/rooms/1/availability?start=2050-01-01&end=2050-01-02", "test-user-key", "", http.StatusOK},
+	{"availability bad start", "GET", "/api/v1/rooms/1/availability?start=tomorrow&end=2050-01-02", "test-user-key", "", http.StatusBadRequest},
+	{"availability end before start", "GET", "/api/v1/rooms/1/availability?start=2050-01-02&end=2050-01-01", "test-user-key", "", http.StatusBadRequest},
+	{"availability bad room id", "GET", "/api/v1/rooms/abc/availability?start=2050-01-01&end=2050-01-02", "test-user-key", "", http.StatusBadRequest},
+	{"availability unknown room", "GET", "/api/v1/rooms/2000/availability?start=2050-01-01&end=2050-01-02", "test-user-key", "", http.StatusNotFound},
+	{"post reservation", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusCreated},
+	{"post reservation room taken", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":100,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusConflict},
+	{"post reservation unknown room", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":2000,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusUnprocessableEntity},
+	{"post reservation invalid fields", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-03","end_date":"2050-01-01","first_name":"Jo","email":"nope"}`,
+		http.StatusUnprocessableEntity},
+	{"post reservation shorter than the minimum stay", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":2,"start_date":"2050-07-01","end_date":"2050-07-03","first_name":"John","last_name":"Smith","email":"john@smith.com"}`,
+		http.StatusUnprocessableEntity},
+	{"post reservation with promo code", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com","promo_code":"SUMMER10"}`,
+		http.StatusCreated},
+	{"post reservation expired promo code", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com","promo_code":"EXPIRED"}`,
+		http.StatusUnprocessableEntity},
+	{"post reservation promo code used up", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com","promo_code":"RACE"}`,
+		http.StatusConflict},
+	{"post reservation with guests", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com","adults":1,"children":1}`,
+		http.StatusCreated},
+	{"post reservation over capacity", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com","adults":3}`,
+		http.StatusUnprocessableEntity},
+	{"post reservation negative children", "POST", "/api/v1/reservations", "test-user-key",
+		`{"room_id":1,"start_date":"2050-01-01","end_date":"2050-01-03","first_name":"John","last_name":"Smith","email":"john@smith.com","children":-1}`,
+		http.StatusUnprocessableEntity},
+	{"quote", "GET", "/api/v1/rooms/2/quote?start=2050-07-01&end=2050-07-04", "test-user-key", "", http.StatusOK},
+	{"quote shorter than the minimum stay", "GET", "/api/v1/rooms/2/quote?start=2050-07-01&end=2050-07-03", "test-user-key", "", http.StatusUnprocessableEntity},
+	{"quote bad dates", "GET", "/api/v1/rooms/2/quote?start=2050-07-03&end=2050-07-01", "test-user-key", "", http.StatusBadRequest},
+	{"post reservation malformed", "POST", "/api/v1/reservations", "test-user-key", `{"room_id":`, http.StatusBadRequest},
+	{"get reservation", "GET", "/api/v1/reservations/1", "test-admin-key", "", http.StatusOK},
+	{"get unknown reservation", "GET", "/api/v1/reservations/2000", "test-admin-key", "", http.StatusNotFound},
+	{"get reservation with user scope", "GET", "/api/v1/reservations/1", "test-user-key", "", http.StatusForbidden},
+	{"get reservation with a key above its user", "GET", "/api/v1/reservations/1", "test-demoted-key", "", http.StatusForbidden},
+	{"no key", "GET", "/api/v1/rooms", "", "", http.StatusUnauthorized},
+	{"unknown key", "GET", "/api/v1/rooms", "nope", "", http.StatusUnauthorized},
+	{"unknown route", "GET", "/api/v1/nope", "test-user-key", "", http.StatusNotFound},
+	{"wrong method", "DELETE", "/api/v1/rooms", "test-user-key", "", http.StatusMethodNotAllowed},
+}
+
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	for _, e := range apiTests {
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		if e.key != "" {
+			req.Header.Set("Authorization", "Bearer "+e.key)
+		}
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s expected %d but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("for %s expected a JSON response, got %q", e.name, ct)
+		}
+
+		var env apiEnvelope
+		err = json.NewDecoder(resp.Body).Decode(&env)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("for %s the body is not a JSON envelope: %v", e.name, err)
+			continue
+		}
+
+		if e.expectedStatusCode >= 400 {
+			if env.Error == nil || env.Error.Status != e.expectedStatusCode {
+				t.Errorf("for %s expected an error envelope with status %d, got %+v", e.name, e.expectedStatusCode, env.Error)
+			}
+		} else if env.Data == nil {
+			t.Errorf("for %s expected data in the envelope", e.name)
+		}
+	}
+}
+
+func TestAPI_ValidationFields(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	body := `{"room_id":1,"start_date":"2050-01-03","end_date":"2050-01-01","first_name":"John","last_name":"Smith","email":"nope"}`
+	req, _ := http.NewRequest("POST", ts.URL+"/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer test-user-key")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var env apiEnvelope
+	if err = json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.Error == nil {
+		t.Fatal("expected an error envelope")
+	}
+	for _, field := range []string{"email", "end_date"} {
+		if len(env.Error.Fields[field]) == 0 {
+			t.Errorf("expected a validation message for %s", field)
+		}
+	}
+}
+
+func TestAPI_Quote(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/rooms/2/quote?start=2050-07-01&end=2050-07-04", nil)
+	req.Header.Set("Authorization", "Bearer test-user-key")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var env struct {
+		Data pricing.Quote `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+
+	// three nights of the summer rate of room 2
+	if env.Data.Total != 3*25000 || len(env.Data.Nights) != 3 || env.Data.Currency != pricing.Currency {
+		t.Errorf("got quote %+v", env.Data)
+	}
+}
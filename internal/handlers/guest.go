@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bangn/bookings/internal/forms"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/pricing"
+	"github.com/bangn/bookings/internal/render"
+	"github.com/bangn/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// guestReservation loads the reservation named by the {token} URL parameter,
+// it writes a 404 and returns false when there is none
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return res, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return res, false
+	}
+	return res, true
+}
+
+// GuestReservation shows a reservation to the guest who holds its link
+func (m *Repository) GuestReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderGuestReservation(w, r, res, forms.New(nil))
+}
+
+// renderGuestReservation renders the guest reservation page with the change dates form
+func (m *Repository) renderGuestReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	render.Template(w, r, "guest-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// paidReservationMessage tells the guest why a paid reservation keeps its dates, its total is charged already
+const paidReservationMessage = "This reservation is paid, please contact us to change its dates"
+
+// PostGuestChangeReservation moves the reservation of the guest to other dates, if the room is free then.
+// Paid reservations are refused, the new dates would change a total that is charged already
+func (m *Repository) PostGuestChangeReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	link := fmt.Sprintf("/reservations/%s", res.Token)
+
+	if res.IsCancelled() {
+		m.App.Session.Put(r.Context(), "error", "This reservation has been cancelled")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+	if !res.CanBeChanged() {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+	if res.IsPaid() {
+		m.App.Session.Put(r.Context(), "error", paidReservationMessage)
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end")
+
+	form.DateRange("start", "end", maxStayNights)
+
+	// the new dates are priced with the current rates
+	var quote pricing.Quote
+	if form.Valid() {
+		rooms, err := m.reservationRooms(r.Context(), res)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		quote, err = m.quoteRooms(r.Context(), rooms, form.Date("start"), form.Date("end"))
+		var minStay *pricing.MinStayError
+		if errors.As(err, &minStay) {
+			form.Errors.Add("end", fmt.Sprintf("A stay in %s must last at least %d nights on those dates", res.RoomNames(), minStay.MinNights))
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	// the promo code was redeemed at booking, it keeps applying to the new dates without being checked again
+	if form.Valid() && res.PromoCodeID != 0 {
+		promo, err := m.DB.GetPromoCodeByID(r.Context(), res.PromoCodeID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		quote = pricing.Discount(quote, promo)
+	}
+
+	if !form.Valid() {
+		m.renderGuestReservation(w, r, res, form)
+		return
+	}
+
+	err = m.DB.ChangeReservationDates(r.Context(), res.ID, form.Date("start"), form.Date("end"), quote.Total, quote.Discount)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s is not available for those dates", res.RoomNames()))
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrReservationNotChangeable) {
+		// cancelled or expired while the guest was choosing the new dates
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrReservationPaid) {
+		m.App.Session.Put(r.Context(), "error", paidReservationMessage)
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// PostGuestCancelReservation cancels the reservation of the guest and frees the room
+func (m *Repository) PostGuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	link := fmt.Sprintf("/reservations/%s", res.Token)
+
+	if res.IsCancelled() {
+		m.App.Session.Put(r.Context(), "warning", "This reservation was already cancelled")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	// a paid reservation is refunded, which cancels it as well
+	if res.Status == models.ReservationConfirmed && res.PaymentIntentID != "" {
+		err := m.refundPayment(r.Context(), res, models.ActorGuest)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled and your payment refunded")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.CancelReservation(r.Context(), res.ID, models.ActorGuest)
+	if errors.Is(err, repository.ErrInvalidStatusTransition) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
@@ -1,18 +1,25 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bangn/bookings/internal/config"
 	"github.com/bangn/bookings/internal/driver"
 	"github.com/bangn/bookings/internal/forms"
 	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/metrics"
 	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/occupancy"
+	"github.com/bangn/bookings/internal/pricing"
 	"github.com/bangn/bookings/internal/render"
 	"github.com/bangn/bookings/internal/repository"
 	"github.com/bangn/bookings/internal/repository/dbrepo"
@@ -22,10 +29,18 @@ import (
 // Repo the repository used by the handlers
 var Repo *Repository
 
+// maxStayNights is the longest stay guests can search for or book
+const maxStayNights = 30
+
+// maxPartySize is the largest party guests can search for or book, bigger groups have to call
+const maxPartySize = 12
+
 // Repository is the repository type
 type Repository struct{
 	App *config.AppConfig
 	DB repository.DatabaseRepo
+	// Conn is the connection pool behind DB, Readyz pings it
+	Conn *driver.DB
 }
 
 // NewRepo creates a new repository
@@ -33,6 +48,7 @@ func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	return &Repository{
 		App: a,
 		DB: dbrepo.NewPostgresRepo(a, db.SQL),
+		Conn: db,
 	}
 }
 
@@ -52,7 +68,6 @@ func NewHandlers(r *Repository) {
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	remoteIP := r.RemoteAddr
 	m.App.Session.Put(r.Context(), "remote_ip", remoteIP)
-	m.DB.AllUsers()
 
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
@@ -71,24 +86,37 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Reservation renders the make reservation and display form
+// Reservation renders the make reservation form of the booking draft in the URL
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	draft, ok := m.openBookingDraft(w, r)
 	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if len(draft.RoomIDs) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please choose a room")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if !m.renewBookingDraftHold(w, r, &draft) {
+		return
+	}
+	res := draft.Reservation()
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	rooms, err := m.reservationRooms(r.Context(), res)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't find room")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	res.Room.RoomName = room.RoomName
+	res.Room.RoomName = rooms[0].RoomName
+	res.Rooms = rooms
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	quote, ok := m.quoteReservation(w, r, rooms, res)
+	if !ok {
+		return
+	}
+	res.Total = quote.Total
+	res.Currency = quote.Currency
 
 	startDate :=  res.StartDate.Format("2006-01-02")
 	endtDate :=  res.EndDate.Format("2006-01-02")
@@ -99,9 +127,8 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
 
-	// models.Reservation{} was added to gob, thus we can store it in session,
-	// and we can also get it from session, but here we just initialize an empty reservation struct, then pass it to template,
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		// initialize an empty form, then pass data to it, then return data or errors back via post handler
 		Form: forms.New(nil),
@@ -111,16 +138,26 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 }
 
 
-// PostReservation handles the reservation form submission
+// PostReservation handles the reservation form submission of the booking draft in the URL
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	draft, ok := m.openBookingDraft(w, r)
 	if !ok {
-		helpers.ServerError(w, errors.New("Can't get from session"))
+		return
+	}
+	if len(draft.RoomIDs) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please choose a room")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
 	}
+	// the guest may have taken longer than the hold to fill in the form
+	if !m.renewBookingDraftHold(w, r, &draft) {
+		return
+	}
+	reservation := draft.Reservation()
 
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -131,90 +168,315 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	form := forms.New(r.PostForm)
 
-	form.Required("first_name", "last_name", "email")
+	form.Required("first_name", "last_name", "email", "adults")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	form.IntBetween("adults", 1, maxPartySize)
+	if form.Get("children") != "" {
+		form.IntBetween("children", 0, maxPartySize)
+	}
+	form.IsPromoCode("promo_code")
+
+	reservation.Adults = form.Int("adults")
+	reservation.Children = form.Int("children")
+
+	// price the stay again, the rates may have changed since the form was shown
+	rooms, err := m.reservationRooms(r.Context(), reservation)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	reservation.Room.RoomName = rooms[0].RoomName
+	reservation.Rooms = rooms
+	quote, ok := m.quoteReservation(w, r, rooms, reservation)
+	if !ok {
+		return
+	}
+	reservation.Total = quote.Total
+	reservation.Currency = quote.Currency
+
+	if form.Valid() && form.PromoCode("promo_code") != "" {
+		promo, err := m.DB.GetPromoCodeByCode(r.Context(), form.PromoCode("promo_code"))
+		if errors.Is(err, sql.ErrNoRows) {
+			form.Errors.Add("promo_code", "This promo code does not exist")
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		} else if discounted, err := pricing.ApplyPromoCode(quote, promo, time.Now()); err != nil {
+			form.Errors.Add("promo_code", err.Error())
+		} else {
+			quote = discounted
+			reservation.PromoCodeID = promo.ID
+			reservation.Total = quote.Total
+			reservation.Discount = quote.Discount
+		}
+	}
 
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		// models.Reservation{} was added to gob, thus we can store it in session,
-		// here session can serialize the reservation struct, gotten from form, and pass it back to template, 
-		// so that the user doesn't have to re-enter the data they already entered, just correct the errors
-		data["reservation"] = reservation
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+		renderMakeReservation(w, r, form, reservation, quote)
 		return
 	}
 
-	// Insert reservation into database
-	newReservationId, err := m.DB.InsertReservation(reservation)
+	reservation.Status = m.bookingStatus(reservation.Total)
+
+	// the token is the key of the link the guest uses to come back to the reservation
+	reservation.Token, err = helpers.RandomToken(32)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
+	// insert the reservation and block the room in one go,
+	// BookRoom re-checks availability so two guests can not get the same room
+	newReservationId, err := m.DB.BookRoom(r.Context(), reservation)
+	countBooking("web", err)
+	if errors.Is(err, repository.ErrBookingDraftBooked) {
+		// the form was submitted twice, the first submission booked it
+		http.Redirect(w, r, bookingDraftPath(draft.ID, "/summary"), http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been booked by someone else for those dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if errors.Is(err, repository.ErrPromoCodeUnavailable) {
+		// somebody else took the last redemption while the guest filled in the form
+		form.Errors.Add("promo_code", pricing.ErrPromoCodeUsedUp.Error())
+		reservation.PromoCodeID = 0
+		reservation.Total = quote.Subtotal
+		reservation.Discount = 0
+		renderMakeReservation(w, r, form, reservation, quote)
+		return
+	} else if errors.Is(err, repository.ErrOverCapacity) {
+		capacity := 0
+		for _, room := range rooms {
+			capacity += room.Capacity
+		}
+		form.Errors.Add("adults", fmt.Sprintf("Sorry, %s can only sleep %d guests", reservation.RoomNames(), capacity))
+		renderMakeReservation(w, r, form, reservation, quote)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	reservation.ID = newReservationId
 
-	// create room restriction struct
-	restriction := models.RoomRestriction{
-		StartDate:	reservation.StartDate,
-		EndDate:	reservation.EndDate,
-		RoomID:		reservation.RoomID,
-		ReservationID: newReservationId,
-		RestrictionID: 1,
+	// paid reservations are confirmed by mail once the payment is received
+	if !reservation.IsPendingPayment() {
+		m.sendReservationMails(reservation)
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// the summary reads the reservation back through the draft, now booked
+	http.Redirect(w, r, bookingDraftPath(draft.ID, "/summary"), http.StatusSeeOther)
+}
+
+// renderMakeReservation shows the make reservation form again with the errors of the form
+func renderMakeReservation(w http.ResponseWriter, r *http.Request, form *forms.Form, res models.Reservation, quote pricing.Quote) {
+	data := make(map[string]interface{})
+	// the reservation carries what the guest entered, so they only have to correct the errors
+	data["reservation"] = res
+	data["quote"] = quote
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// quoteRoom prices a stay in the room with the rates stored for it
+func (m *Repository) quoteRoom(ctx context.Context, room models.Room, start, end time.Time) (pricing.Quote, error) {
+	rates, err := m.DB.GetRatesForRoom(ctx, room.ID, start, end)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		return pricing.Quote{}, err
 	}
 
-	// save reservation in session, then next page will get it from session via redirect
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	// redirect to summary page
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	return pricing.NewQuote(room, start, end, rates)
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// quoteRooms prices a stay in every room and puts the quotes together, see pricing.Combine
+func (m *Repository) quoteRooms(ctx context.Context, rooms []models.Room, start, end time.Time) (pricing.Quote, error) {
+	quotes := make([]pricing.Quote, len(rooms))
+	for i, room := range rooms {
+		quote, err := m.quoteRoom(ctx, room, start, end)
+		if err != nil {
+			return quote, err
+		}
+		quotes[i] = quote
+	}
+
+	return pricing.Combine(quotes...), nil
 }
 
+// reservationRooms loads every room of a reservation in the booking funnel
+func (m *Repository) reservationRooms(ctx context.Context, res models.Reservation) ([]models.Room, error) {
+	var rooms []models.Room
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+	for _, roomID := range res.RoomIDs() {
+		room, err := m.DB.GetRoomByID(ctx, roomID)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, nil
+}
+
+// quoteReservation prices the stay of a reservation in the booking funnel. A stay shorter than the rates allow
+// sends the guest back to the search with a message; it writes the response and returns false on failure
+func (m *Repository) quoteReservation(w http.ResponseWriter, r *http.Request, rooms []models.Room, res models.Reservation) (pricing.Quote, bool) {
+	quotes := make([]pricing.Quote, len(rooms))
+
+	for i, room := range rooms {
+		quote, err := m.quoteRoom(r.Context(), room, res.StartDate, res.EndDate)
+
+		var minStay *pricing.MinStayError
+		if errors.As(err, &minStay) {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s can only be booked for %d nights or more on those dates", room.RoomName, minStay.MinNights))
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return quote, false
+		} else if errors.Is(err, pricing.ErrInvalidRange) {
+			m.App.Session.Put(r.Context(), "error", "Please search for your dates again")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return quote, false
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return quote, false
+		}
+
+		quotes[i] = quote
+	}
+
+	return pricing.Combine(quotes...), true
+}
+
+// sendReservationMails lets the guest and the owner know about a new reservation,
+// the mails are sent in the background
+func (m *Repository) sendReservationMails(reservation models.Reservation) {
+	m.queueMail(models.MailData{
+		To:       reservation.Email,
+		Subject:  "Reservation Confirmation",
+		Template: "reservation-confirmation",
+		Data: map[string]interface{}{
+			"Reservation": reservation,
+			"Total":       render.Money(reservation.Total),
+			"Link":        fmt.Sprintf("%s/reservations/%s", m.App.BaseURL, reservation.Token),
+		},
+	})
+	m.queueMail(models.MailData{
+		To:       m.App.OwnerEmail,
+		Subject:  "Reservation Notification",
+		Template: "reservation-notification",
+		Data: map[string]interface{}{
+			"Reservation": reservation,
+			"Total":       render.Money(reservation.Total),
+			"AdminLink":   fmt.Sprintf("%s/admin/reservations/new/%d", m.App.BaseURL, reservation.ID),
+		},
+	})
+}
+
+// queueMail hands a mail to the background mailer without ever blocking the request
+func (m *Repository) queueMail(md models.MailData) {
+	select {
+	case m.App.MailChan <- md:
+	default:
+		m.App.Logger.Error("mail queue is full, dropping the mail", "template", md.Template, "to", md.To)
+	}
+}
+
+// countBooking counts the outcome of BookRoom in the booking metrics, channel is web or api
+func countBooking(channel string, err error) {
+	switch {
+	case err == nil:
+		metrics.ReservationsCreated.Inc(channel)
+	case errors.Is(err, repository.ErrRoomNotAvailable):
+		metrics.ReservationsRejected.Inc(channel, "unavailable")
+	case errors.Is(err, repository.ErrOverCapacity):
+		metrics.ReservationsRejected.Inc(channel, "over_capacity")
+	case errors.Is(err, repository.ErrPromoCodeUnavailable):
+		metrics.ReservationsRejected.Inc(channel, "promo_code")
+	}
+}
+
+// Rooms lists the rooms of the catalogue
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the page of the room named by the slug in the URL
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 
 // Availability render search availability page
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
 }
 
 // PostAvailability render search availability page
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
-
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
-	endDate, err := time.Parse(layout, end)
-	if err != nil {
-		helpers.ServerError(w, err)
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end", "adults")
+	form.DateRange("start", "end", maxStayNights)
+	form.IntBetween("adults", 1, maxPartySize)
+	if form.Get("children") != "" {
+		form.IntBetween("children", 0, maxPartySize)
+	}
+	if form.Valid() && form.Int("adults")+form.Int("children") > maxPartySize {
+		form.Errors.Add("children", fmt.Sprintf("We can host parties of up to %d guests, please call us for bigger groups", maxPartySize))
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	startDate := form.Date("start")
+	endDate := form.Date("end")
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if  err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -225,16 +487,37 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// format data
-	data := make(map[string]interface{})
-	data["rooms"] = rooms
-
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate: endDate,
+		Adults: form.Int("adults"),
+		Children: form.Int("children"),
+	}
+
+	options := occupancy.Options(rooms, res.Guests())
+	if len(options) == 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, we do not have rooms for %d guests on those dates", res.Guests()))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	// the draft carries the search to the room the guest chooses, every tab searching gets its own
+	draft, err := m.newBookingDraft(r.Context(), models.BookingDraft{
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		Adults:    res.Adults,
+		Children:  res.Children,
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// format data
+	data := make(map[string]interface{})
+	data["options"] = options
+	data["reservation"] = res
+	data["draft"] = draft
 
 	// redirect to the displayment of available rooms
 	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
@@ -248,37 +531,74 @@ type jsonResponse struct {
 	RoomID	string `json:"room_id"`
 	StartDate	string `json:"start_date"`
 	EndDate		string	`json:"end_date"`
+	Errors  map[string][]string `json:"errors,omitempty"`
 }
 
 // AvailabilityJSON handle request for availability and send JSON response
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	// the room pages post a multipart FormData
+	err := r.ParseMultipartForm(maxAPIBodyBytes)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeAvailabilityJSON(w, http.StatusBadRequest, jsonResponse{Message: "Invalid request"})
+		return
+	}
 
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
+	form := forms.New(r.Form)
+	form.Required("room_id", "start", "end")
+	form.IsInt("room_id")
+	form.DateRange("start", "end", maxStayNights)
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	sd := form.Get("start")
+	ed := form.Get("end")
 
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	if !form.Valid() {
+		message := "Invalid request"
+		for _, field := range []string{"room_id", "start", "end"} {
+			if e := form.Errors.Get(field); e != "" {
+				message = e
+				break
+			}
+		}
+
+		writeAvailabilityJSON(w, http.StatusBadRequest, jsonResponse{
+			Message: message,
+			StartDate: sd,
+			EndDate: ed,
+			RoomID: form.Get("room_id"),
+			Errors: form.Errors,
+		})
+		return
+	}
 
-	avalable, _ := m.DB.SearchAvailabilityByDatesByRoomId(startDate, endDate, roomID)
-	resp := jsonResponse{
+	roomID := form.Int("room_id")
+
+	avalable, err := m.DB.SearchAvailabilityByDatesByRoomId(r.Context(), form.Date("start"), form.Date("end"), roomID)
+	if err != nil {
+		helpers.LogServerError(r, err)
+		writeAvailabilityJSON(w, http.StatusInternalServerError, jsonResponse{Message: "Error querying the database"})
+		return
+	}
+
+	writeAvailabilityJSON(w, http.StatusOK, jsonResponse{
 		OK: avalable,
 		Message: "",
 		StartDate: sd,
 		EndDate: ed,
 		RoomID: strconv.Itoa(roomID),
-	}
-
+	})
+}
 
+func writeAvailabilityJSON(w http.ResponseWriter, status int, resp jsonResponse) {
 	out, err := json.MarshalIndent(resp, "", "     ")
 	if err != nil {
-		helpers.ServerError(w, err)
+		// only a bug lands here, the response types always encode
+		Repo.App.Logger.Error("encoding JSON", "error", err)
+		helpers.ClientError(w, http.StatusInternalServerError)
+		return
 	}
 
-	
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(out)
 }
 
@@ -287,37 +607,34 @@ func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
 }
 
-// ReservationSummary render reservation summary page
+// ReservationSummary renders the summary of the reservation the booking draft in the URL was booked as
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	// the type assertion .(models.Reservation) checks if the value stored in the session under the key "reservation" is of type models.Reservation.
-	// If the assertion is successful, ok will be true, and reservation will hold the value with the correct type.
-	// If the assertion fails (meaning the value is not of the expected type), ok will be false, and reservation will be the zero value for models.Reservation.
-	if !ok {
-		m.App.ErrorLog.Println("Cannot get reservation from session")
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+	draft, err := m.DB.GetBookingDraft(r.Context(), chi.URLParam(r, "draft"))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Logger.WarnContext(r.Context(), "booking draft of the summary not found")
+		m.App.Session.Put(r.Context(), "error", "Can't find this reservation")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !draft.IsBooked() {
+		http.Redirect(w, r, bookingDraftPath(draft.ID, ""), http.StatusSeeOther)
+		return
 	}
-	// this is type assertion technique in Go, example:
-	// 	var x interface{} = models.Reservation{}
-
-	// r, ok := x.(models.Reservation)
-
-	// ok == true
-	// r contains the struct
 
-	// free up the session, 
-	// since we already got the reservation data from session, we can remove it from session, 
-	// so that it won't take up space in session, and also it won't cause confusion if we have multiple reservations in session, 
-	// we only want to keep the current reservation in session, thus we remove it after we get it
-	m.App.Session.Remove(r.Context(), "reservation")
+	reservation, err := m.DB.GetReservationByID(r.Context(), draft.ReservationID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
 	// create a map to hold data sent to template
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
 
-	log.Println("[Debug] start date: ", reservation.StartDate.Format("2006-01-02"))
+	m.App.Logger.DebugContext(r.Context(), "reservation summary", "start_date", reservation.StartDate.Format("2006-01-02"))
 	sd := reservation.StartDate.Format("2006-01-02")
 	ed := reservation.EndDate.Format("2006-01-02")
 	stringMap := make(map[string]string)
@@ -330,55 +647,267 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// ChooseRoom display list of available rooms
+// ChooseRoom puts the room in the URL in the booking draft, and takes the guest to the make reservation screen
 func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	// parse room ID from req's parameters
 	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
-	m.App.Session.Get(r.Context(), "reservation")
 
-	
-	//
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		helpers.ServerError(w, err)
+	_, err = m.DB.GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	res.RoomID = roomID
+	m.chooseRooms(w, r, []int{roomID})
+}
+
+// ChooseRooms takes the rooms a party is split across, as a comma separated list of ids, puts them in the
+// booking draft and takes the guest to the make reservation screen
+func (m *Repository) ChooseRooms(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("ids"), ",")
+	if len(ids) > occupancy.MaxRooms {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	var roomIDs []int
+	for _, id := range ids {
+		roomID, err := strconv.Atoi(id)
+		if err != nil || slices.Contains(roomIDs, roomID) {
+			helpers.ClientError(w, http.StatusBadRequest)
+			return
+		}
+
+		_, err = m.DB.GetRoomByID(r.Context(), roomID)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		} else if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
 
-	http.Redirect(w, r, "/make-reservation",  http.StatusSeeOther)
+	m.chooseRooms(w, r, roomIDs)
 }
 
-// BookRoom tajes URL query parameters, builds a sessional variable, and takes user to make res screen
+// chooseRooms holds the rooms for the booking draft in the URL and redirects to its make reservation screen.
+// Rooms somebody else holds or booked in the meantime send the guest back to the search
+func (m *Repository) chooseRooms(w http.ResponseWriter, r *http.Request, roomIDs []int) {
+	draft, ok := m.openBookingDraft(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.SetBookingDraftRooms(r.Context(), draft.ID, roomIDs, time.Now().Add(m.App.BookingHold))
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been taken for those dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, bookingDraftPath(draft.ID, ""), http.StatusSeeOther)
+}
+
+// BookRoom takes the room and dates of the URL query parameters, from the room pages, into a new booking draft
+// and takes the guest to the make reservation screen
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	// id, s, e
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	sd := r.URL.Query().Get("s")
-	ed := r.URL.Query().Get("e")
+	form := forms.New(r.URL.Query())
+	form.Required("id", "s", "e")
+	form.IsInt("id")
+	form.DateRange("s", "e", maxStayNights)
+
+	if !form.Valid() {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	roomID := form.Int("id")
+
+	_, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	draft, err := m.newBookingDraft(r.Context(), models.BookingDraft{
+		StartDate: form.Date("s"),
+		EndDate:   form.Date("e"),
+		Adults:    1,
+		RoomIDs:   []int{roomID},
+	})
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been taken for those dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, bookingDraftPath(draft.ID, ""), http.StatusSeeOther)
+}
+
+// ResumeBooking takes the guest back to the last booking draft of their session,
+// the make reservation and summary screens used to live at /make-reservation and /reservation-summary
+func (m *Repository) ResumeBooking(w http.ResponseWriter, r *http.Request) {
+	id := m.App.Session.GetString(r.Context(), "booking_draft")
+	if id == "" {
+		m.App.Session.Put(r.Context(), "error", "Please search for your dates again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, bookingDraftPath(id, ""), http.StatusSeeOther)
+}
+
+// newBookingDraft saves a draft, holding its rooms when it has some, and points the session at it.
+// The draft gets an unguessable ID, it is the key of the URLs of the funnel
+func (m *Repository) newBookingDraft(ctx context.Context, draft models.BookingDraft) (models.BookingDraft, error) {
+	var err error
+	draft.ID, err = helpers.RandomToken(32)
+	if err != nil {
+		return draft, err
+	}
+	draft.ExpiresAt = time.Now().Add(m.App.BookingHold)
+
+	err = m.DB.InsertBookingDraft(ctx, draft)
+	if err != nil {
+		return draft, err
+	}
+
+	// the session only remembers which draft is the last one, see ResumeBooking
+	m.App.Session.Put(ctx, "booking_draft", draft.ID)
+	return draft, nil
+}
+
+// openBookingDraft returns the booking draft in the URL, still open for the guest to book. Unknown drafts and
+// expired ones without rooms send the guest back to the search with a message, booked ones to their summary;
+// it writes the response and returns false then. A draft whose hold expired keeps its rooms until they are held
+// again, see renewBookingDraftHold
+func (m *Repository) openBookingDraft(w http.ResponseWriter, r *http.Request) (models.BookingDraft, bool) {
+	draft, err := m.DB.GetBookingDraft(r.Context(), chi.URLParam(r, "draft"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return draft, false
+	}
+
+	if draft.IsBooked() {
+		http.Redirect(w, r, bookingDraftPath(draft.ID, "/summary"), http.StatusSeeOther)
+		return draft, false
+	}
+
+	if err != nil || (len(draft.RoomIDs) == 0 && draft.IsExpired(time.Now())) {
+		m.App.Session.Put(r.Context(), "error", "Your booking has expired, please search for your dates again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return draft, false
+	}
+
+	return draft, true
+}
+
+// renewBookingDraftHold holds the rooms of the draft for another BookingHold, each time the guest opens or submits
+// the form. A hold that expired is taken again while the rooms are free, so a guest slow to fill in the form only
+// goes back to the search when somebody else took the rooms; it writes the response and returns false then
+func (m *Repository) renewBookingDraftHold(w http.ResponseWriter, r *http.Request, draft *models.BookingDraft) bool {
+	expiresAt := time.Now().Add(m.App.BookingHold)
+	err := m.DB.SetBookingDraftRooms(r.Context(), draft.ID, draft.RoomIDs, expiresAt)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, your booking has expired and the room has been taken since. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return false
+	} else if errors.Is(err, sql.ErrNoRows) {
+		// booked by the same form, submitted twice
+		http.Redirect(w, r, bookingDraftPath(draft.ID, "/summary"), http.StatusSeeOther)
+		return false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return false
+	}
+
+	draft.ExpiresAt = expiresAt
+	return true
+}
+
+// bookingDraftPath returns the path of a screen of the booking funnel, "" is the make reservation screen
+func bookingDraftPath(id, screen string) string {
+	return "/bookings/" + id + screen
+}
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+// ShowLogin renders the login screen
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
 
-	var res models.Reservation
+// PostLogin logs the user in and binds the identity to a fresh session
+func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
+	// always renew the token on login (or logout) to prevent session fixation attacks
+	_ = m.App.Session.RenewToken(r.Context())
 
-	room, err := m.DB.GetRoomByID(roomID)
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
 	}
 
-	res.StartDate = startDate
-	res.EndDate = endDate
-	res.RoomID = roomID
-	res.Room.RoomName = room.RoomName 
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
-}
\ No newline at end of file
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.Authenticate(r.Context(), email, password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// the access level is not kept in the session, Auth reads the current one of the user on every request
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "user", user)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+
+	if user.AccessLevel >= models.AccessLevelAdmin {
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs the user out and throws the session away
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	m.App.Session.Put(r.Context(), "flash", "Logged out")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -2,13 +2,21 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bangn/bookings/internal/helpers"
 	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/repository"
+	"github.com/go-chi/chi"
 )
 
 type postData struct {
@@ -39,6 +47,30 @@ var theTests = []struct {
 	// 	{key: "start", value: "2020-01-01"},
 	// 	{key: "end", value: "2020-01-02"},
 	// }, http.StatusOK},
+	{"login", "/user/login", "GET", []postData{}, http.StatusOK},
+	{"rooms", "/rooms", "GET", []postData{}, http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", []postData{}, http.StatusOK},
+	{"unknown room", "/rooms/nope", "GET", []postData{}, http.StatusNotFound},
+	{"old generals page", "/generals-quarters", "GET", []postData{}, http.StatusOK},
+	{"old majors page", "/majors-suite", "GET", []postData{}, http.StatusOK},
+	{"guest reservation", "/reservations/valid-token", "GET", []postData{}, http.StatusOK},
+	{"cancelled guest reservation", "/reservations/cancelled-token", "GET", []postData{}, http.StatusOK},
+	{"unknown guest reservation", "/reservations/unknown-token", "GET", []postData{}, http.StatusNotFound},
+	{"admin dashboard", "/admin/dashboard", "GET", []postData{}, http.StatusOK},
+	{"admin new reservations", "/admin/reservations-new", "GET", []postData{}, http.StatusOK},
+	{"admin all reservations", "/admin/reservations-all", "GET", []postData{}, http.StatusOK},
+	{"admin reservations calendar", "/admin/reservations-calendar", "GET", []postData{}, http.StatusOK},
+	{"admin reservations calendar of a month", "/admin/reservations-calendar?y=2050&m=2", "GET", []postData{}, http.StatusOK},
+	{"admin reservations calendar bad month", "/admin/reservations-calendar?y=2050&m=13", "GET", []postData{}, http.StatusBadRequest},
+	{"admin show reservation", "/admin/reservations/new/1", "GET", []postData{}, http.StatusOK},
+	{"admin api keys", "/admin/api-keys", "GET", []postData{}, http.StatusOK},
+	{"admin rooms", "/admin/rooms", "GET", []postData{}, http.StatusOK},
+	{"admin new room", "/admin/rooms/new", "GET", []postData{}, http.StatusOK},
+	{"admin show room", "/admin/rooms/1", "GET", []postData{}, http.StatusOK},
+	{"admin show unknown room", "/admin/rooms/2000", "GET", []postData{}, http.StatusNotFound},
+	{"room calendar", "/rooms/1/calendar.ics?token=generals-calendar-token", "GET", []postData{}, http.StatusOK},
+	{"room calendar wrong token", "/rooms/1/calendar.ics?token=nope", "GET", []postData{}, http.StatusNotFound},
+	{"guest reservation calendar", "/reservations/valid-token/calendar.ics", "GET", []postData{}, http.StatusOK},
 	// {"make reservation post", "/make-reservation", "POST", []postData{
 	// 	{key: "first_name", value: "John"},
 	// 	{key: "last_name", value: "Doe"},
@@ -79,29 +111,1058 @@ func TestHandlers(t *testing.T) {
 	}
 }
 
+// insertTestDraft saves a booking draft open for another hour, unless it says otherwise, and returns its ID
+func insertTestDraft(t *testing.T, draft models.BookingDraft) string {
+	t.Helper()
+
+	draft.ID, _ = helpers.RandomToken(16)
+	if draft.ExpiresAt.IsZero() {
+		draft.ExpiresAt = time.Now().Add(time.Hour)
+	}
+	if err := Repo.DB.InsertBookingDraft(context.Background(), draft); err != nil {
+		t.Fatal(err)
+	}
+	return draft.ID
+}
+
+// withDraft sets the URL parameters of the /bookings/{draft} routes on the context of a request
+func withDraft(ctx context.Context, id string, params ...string) context.Context {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("draft", id)
+	for i := 0; i+1 < len(params); i += 2 {
+		rctx.URLParams.Add(params[i], params[i+1])
+	}
+	return context.WithValue(ctx, chi.RouteCtxKey, rctx)
+}
+
 func TestRepository_Reservation(t *testing.T) {
-	reservation := models.Reservation{
-		RoomID: 1,
-		Room: models.Room{
-			ID: 1,
-			RoomName: "General's Quarter",
-		},
+	january := models.BookingDraft{
+		StartDate: time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    1,
+		RoomIDs:   []int{1},
+	}
+	expired := january
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	// room 98 was taken since the draft was made
+	expiredTaken := expired
+	expiredTaken.RoomIDs = []int{98}
+	noRoom := january
+	noRoom.RoomIDs = nil
+
+	open := insertTestDraft(t, january)
+	booked := insertTestDraft(t, january)
+	if _, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: 1, Adults: 1, DraftID: booked}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		draft              string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"open draft", open, http.StatusOK, ""},
+		{"booked draft", booked, http.StatusSeeOther, "/bookings/" + booked + "/summary"},
+		{"expired draft, room still free", insertTestDraft(t, expired), http.StatusOK, ""},
+		{"expired draft, room taken since", insertTestDraft(t, expiredTaken), http.StatusSeeOther, "/search-availability"},
+		{"draft without a room", insertTestDraft(t, noRoom), http.StatusSeeOther, "/search-availability"},
+		{"unknown draft", "nope", http.StatusSeeOther, "/search-availability"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/bookings/"+e.draft, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withDraft(ctx, e.draft))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.Reservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_ReservationSummary(t *testing.T) {
+	draft := models.BookingDraft{
+		StartDate: time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    1,
+		RoomIDs:   []int{1},
+	}
+	open := insertTestDraft(t, draft)
+	booked := insertTestDraft(t, draft)
+	if _, err := Repo.DB.BookRoom(context.Background(), models.Reservation{RoomID: 1, Adults: 1, DraftID: booked}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name               string
+		draft              string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"booked draft", booked, http.StatusOK, ""},
+		{"draft not booked yet", open, http.StatusSeeOther, "/bookings/" + open},
+		{"unknown draft", "nope", http.StatusTemporaryRedirect, "/"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/bookings/"+e.draft+"/summary", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withDraft(ctx, e.draft))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ReservationSummary)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+	}
+}
+
+func TestRepository_ResumeBooking(t *testing.T) {
+	for draft, expectedLocation := range map[string]string{"abc": "/bookings/abc", "": "/search-availability"} {
+		req, _ := http.NewRequest("GET", "/make-reservation", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if draft != "" {
+			session.Put(ctx, "booking_draft", draft)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ResumeBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != expectedLocation {
+			t.Errorf("draft %q: got %d to %q, wanted a redirect to %q", draft, rr.Code, rr.Header().Get("Location"), expectedLocation)
+		}
+	}
+}
+
+var adminShowReservationTests = []struct {
+	name               string
+	src                string
+	id                 string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"existing reservation", "all", "1", http.StatusOK, ""},
+	{"missing reservation", "new", "3", http.StatusSeeOther, "/admin/reservations-new"},
+	{"non numeric id", "all", "abc", http.StatusBadRequest, ""},
+	{"unknown source list", "old", "1", http.StatusNotFound, ""},
+}
+
+func TestRepository_AdminShowReservation(t *testing.T) {
+	for _, e := range adminShowReservationTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/"+e.src+"/"+e.id, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", e.src)
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+	}
+}
+
+var processReservationTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedFlash      string
+}{
+	{"new reservation", "5", http.StatusSeeOther, "/admin/reservations-new", "flash"},
+	{"confirmed reservation", "1", http.StatusSeeOther, "/admin/reservations/new/1", "error"},
+	{"missing reservation", "3", http.StatusNotFound, "", ""},
+}
+
+// filterRecordingRepo is the test repository recording the filters the reservations are listed with
+type filterRecordingRepo struct {
+	repository.DatabaseRepo
+	filters []models.ReservationFilter
+}
+
+func (m *filterRecordingRepo) AllReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error) {
+	m.filters = append(m.filters, filter)
+	return m.DatabaseRepo.AllReservations(ctx, filter)
+}
+
+var reservationFilterTests = []struct {
+	name           string
+	query          string
+	expectedFilter *models.ReservationFilter
+	expectedError  string
+}{
+	{"no filter", "", &models.ReservationFilter{}, ""},
+	{"every filter", "?status=confirmed&from=2050-01-01&to=2050-01-31&room=2&guest=+Smith+", &models.ReservationFilter{
+		Status: models.ReservationConfirmed,
+		From:   time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2050, time.January, 31, 0, 0, 0, 0, time.UTC),
+		RoomID: 2,
+		Guest:  "Smith",
+	}, ""},
+	{"unknown status", "?status=lost", nil, "status"},
+	{"malformed date", "?from=01/01/2050", nil, "from"},
+	{"to before from", "?from=2050-01-31&to=2050-01-01", nil, "to"},
+	{"malformed room", "?room=generals", nil, "room"},
+}
+
+func TestRepository_AdminAllReservations_Filters(t *testing.T) {
+	for _, e := range reservationFilterTests {
+		db := &filterRecordingRepo{DatabaseRepo: Repo.DB}
+		repo := &Repository{App: &app, DB: db}
+
+		req, _ := http.NewRequest("GET", "/admin/reservations-all"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(repo.AdminAllReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+		if e.expectedFilter == nil {
+			if len(db.filters) != 0 {
+				t.Errorf("%s: reservations were listed with a malformed filter", e.name)
+			}
+			if !strings.Contains(rr.Body.String(), `is-invalid" id="`+e.expectedError+`"`) {
+				t.Errorf("%s: expected the %s filter marked as invalid", e.name, e.expectedError)
+			}
+			continue
+		}
+		if len(db.filters) != 1 || db.filters[0] != *e.expectedFilter {
+			t.Errorf("%s: got filters %+v, wanted %+v", e.name, db.filters, *e.expectedFilter)
+		}
+	}
+}
+
+func TestRepository_AdminProcessReservation(t *testing.T) {
+	for _, e := range processReservationTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/new/"+e.id+"/process", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "new")
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: redirected to %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, e.expectedFlash) == "" {
+			t.Errorf("%s: expected a %s message in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+var loginTests = []struct {
+	name               string
+	email              string
+	password           string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid credentials", "admin@example.com", "password", http.StatusSeeOther, "/admin/dashboard"},
+	{"wrong password", "admin@example.com", "wrong", http.StatusSeeOther, "/user/login"},
+	{"unknown user", "nobody@example.com", "password", http.StatusSeeOther, "/user/login"},
+	{"invalid form", "not-an-email", "", http.StatusOK, ""},
+}
+
+func TestRepository_PostLogin(t *testing.T) {
+	for _, e := range loginTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+
+		loggedIn := session.Exists(ctx, "user_id")
+		if loggedIn != (e.expectedLocation == "/admin/dashboard") {
+			t.Errorf("%s: user_id in session is %v", e.name, loggedIn)
+		}
+	}
+}
+
+var postReservationTests = []struct {
+	name               string
+	roomIDs            []int
+	adults             string
+	promoCode          string
+	expectedStatusCode int
+	// expectedLocation is a path of the funnel when it starts with /bookings/{draft}
+	expectedLocation string
+	// expectedTotal is checked on the reservation the draft was booked as when the booking succeeds
+	expectedTotal int
+}{
+	{"room booked", []int{1}, "2", "", http.StatusSeeOther, "/bookings/{draft}/summary", 24000},
+	{"room taken in the meantime", []int{100}, "2", "", http.StatusSeeOther, "/search-availability", 0},
+	{"database failure", []int{1000}, "2", "", http.StatusInternalServerError, "", 0},
+	{"stay shorter than the rate allows", []int{2}, "2", "", http.StatusSeeOther, "/search-availability", 0},
+	{"percent promo code", []int{1}, "2", "summer10", http.StatusSeeOther, "/bookings/{draft}/summary", 21600},
+	{"fixed promo code", []int{1}, "2", "GENERALS50", http.StatusSeeOther, "/bookings/{draft}/summary", 19000},
+	{"malformed promo code", []int{1}, "2", "10% off", http.StatusOK, "", 0},
+	{"unknown promo code", []int{1}, "2", "NOPE", http.StatusOK, "", 0},
+	{"expired promo code", []int{1}, "2", "EXPIRED", http.StatusOK, "", 0},
+	{"promo code for another room", []int{3}, "2", "GENERALS50", http.StatusOK, "", 0},
+	{"more guests than the room sleeps", []int{1}, "3", "", http.StatusOK, "", 0},
+	{"no adults", []int{1}, "0", "", http.StatusOK, "", 0},
+	{"promo code used up while booking", []int{1}, "2", "RACE", http.StatusOK, "", 0},
+}
+
+func TestRepository_PostReservation(t *testing.T) {
+	for _, e := range postReservationTests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("phone", "555-555-5555")
+		postedData.Add("promo_code", e.promoCode)
+		postedData.Add("adults", e.adults)
+
+		// two nights, room 2 has a minimum stay of three in the summer of 2050
+		draft := insertTestDraft(t, models.BookingDraft{
+			StartDate: time.Date(2050, time.July, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, time.July, 3, 0, 0, 0, 0, time.UTC),
+			RoomIDs:   e.roomIDs,
+		})
+
+		req, _ := http.NewRequest("POST", "/bookings/"+draft, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(withDraft(ctx, draft))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		expectedLocation := strings.Replace(e.expectedLocation, "{draft}", draft, 1)
+		if e.expectedLocation != "" && rr.Header().Get("Location") != expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), expectedLocation)
+		}
+		if e.expectedTotal > 0 {
+			res := bookedReservation(t, draft)
+			if res.Total != e.expectedTotal {
+				t.Errorf("%s: got total %d, wanted %d", e.name, res.Total, e.expectedTotal)
+			}
+		}
+	}
+}
+
+// bookedReservation returns the reservation a booking draft was booked as
+func bookedReservation(t *testing.T, draftID string) models.Reservation {
+	t.Helper()
+
+	draft, err := Repo.DB.GetBookingDraft(context.Background(), draftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !draft.IsBooked() {
+		t.Fatalf("draft %s was not booked", draftID)
+	}
+	res, err := Repo.DB.GetReservationByID(context.Background(), draft.ReservationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRepository_PostReservation_Twice(t *testing.T) {
+	draft := insertTestDraft(t, models.BookingDraft{
+		StartDate: time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+		RoomIDs:   []int{1},
+	})
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("adults", "2")
+
+	var reservationIDs []int
+	for range 2 {
+		req, _ := http.NewRequest("POST", "/bookings/"+draft, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(withDraft(getCtx(req), draft))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/bookings/"+draft+"/summary" {
+			t.Errorf("got %d to %q, wanted the summary", rr.Code, rr.Header().Get("Location"))
+		}
+		reservationIDs = append(reservationIDs, bookedReservation(t, draft).ID)
+	}
+
+	// the second submission finds the draft booked and does not book it again
+	if reservationIDs[0] != reservationIDs[1] {
+		t.Errorf("the draft was booked twice, as %v", reservationIDs)
+	}
+}
+
+func TestRepository_PostReservation_ExpiredHold(t *testing.T) {
+	// the guest took longer than the hold to fill in the form, nobody took the room in the meantime
+	draft := insertTestDraft(t, models.BookingDraft{
+		StartDate: time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+		RoomIDs:   []int{1},
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("adults", "2")
+
+	req, _ := http.NewRequest("POST", "/bookings/"+draft, strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(withDraft(getCtx(req), draft))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/bookings/"+draft+"/summary" {
+		t.Fatalf("got %d to %q, wanted the summary", rr.Code, rr.Header().Get("Location"))
+	}
+	if res := bookedReservation(t, draft); res.RoomID != 1 {
+		t.Errorf("expected room 1 to be booked, got room %d", res.RoomID)
+	}
+}
+
+func TestRepository_PostReservation_SeveralRooms(t *testing.T) {
+	for adults, expectedStatusCode := range map[string]int{"5": http.StatusSeeOther, "7": http.StatusOK} {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("adults", adults)
+
+		draft := insertTestDraft(t, models.BookingDraft{
+			StartDate: time.Date(2050, time.January, 3, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, time.January, 5, 0, 0, 0, 0, time.UTC),
+			RoomIDs:   []int{1, 2},
+		})
+
+		req, _ := http.NewRequest("POST", "/bookings/"+draft, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(withDraft(ctx, draft))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != expectedStatusCode {
+			t.Errorf("%s adults: got status %d, wanted %d", adults, rr.Code, expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther {
+			// two nights in both rooms
+			res := bookedReservation(t, draft)
+			if res.Total != 2*(12000+18000) {
+				t.Errorf("%s adults: got total %d, wanted %d", adults, res.Total, 2*(12000+18000))
+			}
+		}
+	}
+}
+
+var guestChangeReservationTests = []struct {
+	name               string
+	token              string
+	start              string
+	end                string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"dates changed", "valid-token", "2050-01-01", "2050-01-03", http.StatusSeeOther, "/reservations/valid-token"},
+	{"cancelled reservation", "cancelled-token", "2050-01-01", "2050-01-03", http.StatusSeeOther, "/reservations/cancelled-token"},
+	{"paid reservation", "paid-token", "2050-01-01", "2050-01-05", http.StatusSeeOther, "/reservations/paid-token"},
+	{"reservation expired while changed", "expiring-token", "2050-01-01", "2050-01-03", http.StatusSeeOther, "/reservations/expiring-token"},
+	{"invalid date", "valid-token", "2050-13-01", "2050-01-03", http.StatusOK, ""},
+	{"end before start", "valid-token", "2050-01-03", "2050-01-01", http.StatusOK, ""},
+	{"unknown token", "unknown-token", "2050-01-01", "2050-01-03", http.StatusNotFound, ""},
+}
+
+func TestRepository_PostGuestChangeReservation(t *testing.T) {
+	for _, e := range guestChangeReservationTests {
+		postedData := url.Values{}
+		postedData.Add("start", e.start)
+		postedData.Add("end", e.end)
+
+		req, _ := http.NewRequest("POST", "/reservations/"+e.token+"/change", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostGuestChangeReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+		if (e.token == "paid-token" || e.token == "expiring-token") && session.GetString(ctx, "flash") != "" {
+			t.Errorf("%s: the guest is told the dates were changed", e.name)
+		}
+	}
+}
+
+func TestRepository_PostGuestCancelReservation(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/reservations/valid-token/cancel", nil)
+	ctx := getCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", "valid-token")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostGuestCancelReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostGuestCancelReservation returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if session.GetString(ctx, "flash") == "" {
+		t.Error("no confirmation flash message put in session")
+	}
+}
+
+func TestRepository_buildCalendar(t *testing.T) {
+	first := time.Date(2050, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	rows, err := Repo.buildCalendar(context.Background(), first)
+	if err != nil {
+		t.Fatal(err)
 	}
 
+	if len(rows) != 2 {
+		t.Fatalf("expected a row per room, got %d", len(rows))
+	}
+	if len(rows[0].Days) != 28 {
+		t.Errorf("expected 28 days in February 2050, got %d", len(rows[0].Days))
+	}
 
-	req, _ := http.NewRequest("GET", "/maker-reservation", nil)
+	days := rows[0].Days
+	if days[1].ReservationID != 1 || days[2].ReservationID != 1 {
+		t.Error("nights of the 2nd and 3rd should be reserved")
+	}
+	if days[3].ReservationID != 0 {
+		t.Error("departure day should not be reserved")
+	}
+	if days[4].BlockID != 2 {
+		t.Error("night of the 5th should be blocked by the owner")
+	}
+	if days[4].Imported || !days[9].Imported || !days[10].Imported || days[9].BlockID != 3 {
+		t.Error("nights of the 10th and 11th should be blocked by a calendar feed")
+	}
+	if !days[14].Held || days[14].BlockID != 0 || days[4].Held {
+		t.Error("night of the 15th should be held for a guest who is booking")
+	}
+}
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("y", "2050")
+	postedData.Add("m", "2")
+	postedData.Add("block_2_2050-02-10", "on")
+
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	ctx := getCtx(req)
 	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
-	session.Put(ctx, "reservation", reservation)
-	
-	handler := http.HandlerFunc(Repo.Reservation)
+	handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+	handler.ServeHTTP(rr, req)
 
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostReservationsCalendar returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr.Header().Get("Location") != "/admin/reservations-calendar?y=2050&m=2" {
+		t.Errorf("AdminPostReservationsCalendar redirected to %q", rr.Header().Get("Location"))
+	}
+}
+
+// threeNightBlockRepo is the test repository with an owner block of the 20th to the 22nd of February 2050
+// for room 1, it records the nights released
+type threeNightBlockRepo struct {
+	repository.DatabaseRepo
+	released []string
+}
+
+func (m *threeNightBlockRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	restrictions, err := m.DatabaseRepo.GetRestrictionsForRoomByDate(ctx, roomID, start, end)
+	if roomID == 1 {
+		restrictions = append(restrictions, models.RoomRestriction{
+			ID:            5,
+			RoomID:        1,
+			RestrictionID: models.RestrictionOwnerBlock,
+			StartDate:     time.Date(2050, time.February, 20, 0, 0, 0, 0, time.UTC),
+			EndDate:       time.Date(2050, time.February, 23, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	return restrictions, err
+}
+
+func (m *threeNightBlockRepo) DeleteBlockNight(ctx context.Context, id int, date time.Time) error {
+	m.released = append(m.released, fmt.Sprintf("%d %s", id, date.Format("2006-01-02")))
+	return nil
+}
+
+func TestRepository_AdminPostReservationsCalendar_ReleasesOneNight(t *testing.T) {
+	db := &threeNightBlockRepo{DatabaseRepo: Repo.DB}
+	repo := &Repository{App: &app, DB: db}
+
+	postedData := url.Values{}
+	postedData.Add("y", "2050")
+	postedData.Add("m", "2")
+	// the owner block of the 5th stays, the middle night of the three night block is unchecked
+	postedData.Add("block_1_2050-02-05", "on")
+	postedData.Add("block_1_2050-02-20", "on")
+	postedData.Add("block_1_2050-02-22", "on")
+
+	req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(repo.AdminPostReservationsCalendar)
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusOK {
-		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK  )
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminPostReservationsCalendar returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if !slices.Equal(db.released, []string{"5 2050-02-21"}) {
+		t.Errorf("expected only the 21st to be released, got %v", db.released)
+	}
+}
+
+var postAPIKeyTests = []struct {
+	name               string
+	userID             string
+	scope              string
+	expectedStatusCode int
+}{
+	{"admin key", "1", "3", http.StatusSeeOther},
+	{"user key", "2", "1", http.StatusSeeOther},
+	{"scope above the user", "2", "3", http.StatusUnprocessableEntity},
+	{"unknown scope", "1", "2", http.StatusUnprocessableEntity},
+	{"unknown user", "99", "1", http.StatusUnprocessableEntity},
+}
+
+func TestRepository_AdminPostAPIKey(t *testing.T) {
+	for _, e := range postAPIKeyTests {
+		postedData := url.Values{}
+		postedData.Add("name", "partner")
+		postedData.Add("user_id", e.userID)
+		postedData.Add("scope", e.scope)
+
+		req, _ := http.NewRequest("POST", "/admin/api-keys", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostAPIKey)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		// the plain key is handed over through the session, once
+		key := session.GetString(ctx, "new_api_key")
+		if (rr.Code == http.StatusSeeOther) != strings.HasPrefix(key, apiKeyPrefix) {
+			t.Errorf("%s: got new key %q in session", e.name, key)
+		}
+	}
+}
+
+var postAvailabilityTests = []struct {
+	name               string
+	start              string
+	end                string
+	adults             string
+	children           string
+	expectedStatusCode int
+	// expectedOptions is the number of ways to host the party offered on the page
+	expectedOptions int
+}{
+	{"valid dates, no room free", "2050-01-01", "2050-01-03", "2", "", http.StatusSeeOther, 0},
+	{"malformed date", "01/01/2050", "2050-01-03", "2", "", http.StatusOK, 0},
+	{"end before start", "2050-01-03", "2050-01-01", "2", "", http.StatusOK, 0},
+	{"in the past", "2020-01-01", "2020-01-03", "2", "", http.StatusOK, 0},
+	{"too long", "2050-01-01", "2050-06-01", "2", "", http.StatusOK, 0},
+	{"every room fits", "2051-01-01", "2051-01-03", "2", "", http.StatusOK, 2},
+	{"only the big room fits", "2051-01-01", "2051-01-03", "2", "1", http.StatusOK, 1},
+	{"party split across rooms", "2051-01-01", "2051-01-03", "3", "2", http.StatusOK, 1},
+	{"party too big for the rooms", "2051-01-01", "2051-01-03", "5", "2", http.StatusSeeOther, 0},
+	{"no adults", "2051-01-01", "2051-01-03", "0", "2", http.StatusOK, 0},
+	{"missing adults", "2051-01-01", "2051-01-03", "", "", http.StatusOK, 0},
+	{"malformed children", "2051-01-01", "2051-01-03", "2", "some", http.StatusOK, 0},
+	{"party too big to book", "2051-01-01", "2051-01-03", "10", "5", http.StatusOK, 0},
+}
+
+func TestRepository_PostAvailability(t *testing.T) {
+	for _, e := range postAvailabilityTests {
+		postedData := url.Values{}
+		postedData.Add("start", e.start)
+		postedData.Add("end", e.end)
+		postedData.Add("adults", e.adults)
+		postedData.Add("children", e.children)
+
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		// a user typo re-renders the search form instead of failing
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if options := strings.Count(rr.Body.String(), "(sleeps "); options != e.expectedOptions {
+			t.Errorf("%s: got %d room options, wanted %d", e.name, options, e.expectedOptions)
+		}
+		if e.expectedOptions > 0 {
+			// every search gets its own draft, the options are chosen in it
+			draft := session.GetString(ctx, "booking_draft")
+			if draft == "" || !strings.Contains(rr.Body.String(), "/bookings/"+draft+"/choose-room") {
+				t.Errorf("%s: expected the options to link to the draft %q of the search", e.name, draft)
+			}
+		}
+	}
+}
+
+var chooseRoomTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"room chosen", "1", http.StatusSeeOther, "/bookings/{draft}"},
+	{"room held by another draft", "99", http.StatusSeeOther, "/search-availability"},
+	{"malformed id", "one", http.StatusBadRequest, ""},
+	{"unknown room", "2000", http.StatusNotFound, ""},
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	for _, e := range chooseRoomTests {
+		draft := insertTestDraft(t, models.BookingDraft{
+			StartDate: time.Date(2051, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2051, time.January, 3, 0, 0, 0, 0, time.UTC),
+			Adults:    2,
+		})
+
+		req, _ := http.NewRequest("GET", "/bookings/"+draft+"/choose-room/"+e.id, nil)
+		req = req.WithContext(withDraft(getCtx(req), draft, "id", e.id))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		expectedLocation := strings.Replace(e.expectedLocation, "{draft}", draft, 1)
+		if e.expectedLocation != "" && rr.Header().Get("Location") != expectedLocation {
+			t.Errorf("%s: got location %q, wanted %q", e.name, rr.Header().Get("Location"), expectedLocation)
+		}
+	}
+}
+
+var chooseRoomsTests = []struct {
+	name               string
+	ids                string
+	expectedStatusCode int
+}{
+	{"two rooms", "1,2", http.StatusSeeOther},
+	{"malformed id", "1,two", http.StatusBadRequest},
+	{"same room twice", "1,1", http.StatusBadRequest},
+	{"too many rooms", "1,2,3,4", http.StatusBadRequest},
+	{"unknown room", "1,2000", http.StatusNotFound},
+}
+
+func TestRepository_ChooseRooms(t *testing.T) {
+	for _, e := range chooseRoomsTests {
+		draft := insertTestDraft(t, models.BookingDraft{
+			StartDate: time.Date(2051, time.January, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2051, time.January, 3, 0, 0, 0, 0, time.UTC),
+			Adults:    5,
+		})
+
+		req, _ := http.NewRequest("GET", "/bookings/"+draft+"/choose-rooms?ids="+e.ids, nil)
+		req = req.WithContext(withDraft(getCtx(req), draft))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ChooseRooms)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther {
+			saved, _ := Repo.DB.GetBookingDraft(context.Background(), draft)
+			if res := saved.Reservation(); len(res.RoomIDs()) != 2 || res.RoomID != 1 {
+				t.Errorf("%s: got rooms %v in the draft", e.name, res.RoomIDs())
+			}
+		}
+	}
+}
+
+func TestRepository_ChooseRooms_ExpiredDraft(t *testing.T) {
+	draft := insertTestDraft(t, models.BookingDraft{
+		StartDate: time.Date(2051, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2051, time.January, 3, 0, 0, 0, 0, time.UTC),
+		Adults:    5,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	req, _ := http.NewRequest("GET", "/bookings/"+draft+"/choose-rooms?ids=1,2", nil)
+	req = req.WithContext(withDraft(getCtx(req), draft))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.ChooseRooms)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("got %d to %q, an expired draft goes back to the search", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+var availabilityJSONTests = []struct {
+	name               string
+	roomID             string
+	start              string
+	end                string
+	expectedStatusCode int
+}{
+	{"valid", "1", "2050-01-01", "2050-01-03", http.StatusOK},
+	{"malformed room id", "one", "2050-01-01", "2050-01-03", http.StatusBadRequest},
+	{"malformed date", "1", "", "2050-01-03", http.StatusBadRequest},
+	{"end before start", "1", "2050-01-03", "2050-01-01", http.StatusBadRequest},
+}
+
+func TestRepository_AvailabilityJSON(t *testing.T) {
+	for _, e := range availabilityJSONTests {
+		postedData := url.Values{}
+		postedData.Add("room_id", e.roomID)
+		postedData.Add("start", e.start)
+		postedData.Add("end", e.end)
+
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: the body is not JSON: %v", e.name, err)
+			continue
+		}
+		if rr.Code == http.StatusBadRequest && (resp.OK || resp.Message == "") {
+			t.Errorf("%s: expected an error message, got %+v", e.name, resp)
+		}
+	}
+}
+
+var bookRoomTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+}{
+	{"valid", "?id=1&s=2050-01-01&e=2050-01-03", http.StatusSeeOther},
+	{"missing room id", "?s=2050-01-01&e=2050-01-03", http.StatusBadRequest},
+	{"malformed room id", "?id=x&s=2050-01-01&e=2050-01-03", http.StatusBadRequest},
+	{"malformed date", "?id=1&s=2050-01-01&e=tomorrow", http.StatusBadRequest},
+	{"unknown room", "?id=2000&s=2050-01-01&e=2050-01-03", http.StatusNotFound},
+	{"room held by another draft", "?id=99&s=2050-01-01&e=2050-01-03", http.StatusSeeOther},
+}
+
+func TestRepository_BookRoom(t *testing.T) {
+	for _, e := range bookRoomTests {
+		req, _ := http.NewRequest("GET", "/book-room"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_BookRoom_StartsADraft(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/book-room?id=1&s=2050-01-01&e=2050-01-03", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.BookRoom)
+	handler.ServeHTTP(rr, req)
+
+	id := session.GetString(ctx, "booking_draft")
+	if rr.Header().Get("Location") != "/bookings/"+id {
+		t.Fatalf("got location %q, wanted the draft %q the session points at", rr.Header().Get("Location"), id)
+	}
+
+	draft, err := Repo.DB.GetBookingDraft(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(draft.RoomIDs) != 1 || draft.RoomIDs[0] != 1 || draft.IsExpired(time.Now()) {
+		t.Errorf("expected the draft to hold room 1, got %+v", draft)
+	}
+}
+
+var postRoomTests = []struct {
+	name               string
+	url                string
+	id                 string
+	slug               string
+	price              string
+	expectedStatusCode int
+}{
+	{"new room", "/admin/rooms/new", "", "colonels-cabin", "95.50", http.StatusSeeOther},
+	{"new room with a taken slug", "/admin/rooms/new", "", "majors-suite", "95.50", http.StatusUnprocessableEntity},
+	{"new room with a bad slug", "/admin/rooms/new", "", "Colonel's Cabin", "95.50", http.StatusUnprocessableEntity},
+	{"new room with a bad price", "/admin/rooms/new", "", "colonels-cabin", "cheap", http.StatusUnprocessableEntity},
+	{"update room keeping its slug", "/admin/rooms/1", "1", "generals-quarters", "120", http.StatusSeeOther},
+	{"update room with a taken slug", "/admin/rooms/1", "1", "majors-suite", "120", http.StatusUnprocessableEntity},
+	{"update unknown room", "/admin/rooms/2000", "2000", "colonels-cabin", "120", http.StatusNotFound},
+}
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	for _, e := range postRoomTests {
+		postedData := url.Values{}
+		postedData.Add("room_name", "Colonel's Cabin")
+		postedData.Add("slug", e.slug)
+		postedData.Add("capacity", "2")
+		postedData.Add("nightly_price", e.price)
+		postedData.Add("images", "/static/images/house.jpg\n/static/images/outside.png")
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+
+		handler := http.HandlerFunc(Repo.AdminPostNewRoom)
+		if e.id != "" {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", e.id)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+			handler = Repo.AdminPostRoom
+		}
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+var deleteRoomTests = []struct {
+	name             string
+	id               string
+	expectedLocation string
+}{
+	{"room without reservations", "2", "/admin/rooms"},
+	{"room with reservations", "1", "/admin/rooms/1"},
+}
+
+func TestRepository_AdminDeleteRoom(t *testing.T) {
+	for _, e := range deleteRoomTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/delete", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: redirected to %q, wanted %q", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
 	}
 }
 
@@ -111,4 +1172,46 @@ func getCtx(req *http.Request) context.Context{
 		log.Println(err)
 	}
 	return ctx
-}
\ No newline at end of file
+}
+var adminReservationStatusTests = []struct {
+	name               string
+	id                 string
+	status             string
+	expectedStatusCode int
+	expectedMessage    string
+}{
+	{"check in", "1", "checked_in", http.StatusSeeOther, "flash"},
+	{"skip a status", "1", "checked_out", http.StatusSeeOther, "error"},
+	{"refund without the provider", "1", "refunded", http.StatusSeeOther, "error"},
+	{"unknown status", "1", "lost", http.StatusSeeOther, "error"},
+	{"unknown reservation", "2000", "checked_in", http.StatusNotFound, ""},
+	{"bad id", "x", "checked_in", http.StatusBadRequest, ""},
+}
+
+func TestRepository_AdminPostReservationStatus(t *testing.T) {
+	for _, e := range adminReservationStatusTests {
+		postedData := url.Values{}
+		postedData.Add("status", e.status)
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/status", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedMessage != "" && session.GetString(ctx, e.expectedMessage) == "" {
+			t.Errorf("%s: expected a %s message in session", e.name, e.expectedMessage)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// readyTimeout is how long Readyz waits for the database
+const readyTimeout = 2 * time.Second
+
+// Healthz tells the load balancer the process is alive, it checks nothing else
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "ok")
+}
+
+// Readyz tells the load balancer whether the application can take requests:
+// the database answers and the templates are loaded. It answers 503 with the failed checks otherwise
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	var failed []string
+
+	if m.Conn == nil {
+		failed = append(failed, "database: not connected")
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		if err := m.Conn.Ping(ctx); err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "readiness check: database", "error", err)
+			failed = append(failed, "database: not answering")
+		}
+	}
+
+	if len(m.App.TemplateCache) == 0 {
+		failed = append(failed, "templates: not loaded")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(failed, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
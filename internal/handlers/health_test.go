@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bangn/bookings/internal/driver"
+)
+
+// pingDriver is a database driver whose connections only answer pings, Open fails for the name "down"
+type pingDriver struct{}
+
+func (pingDriver) Open(name string) (sqldriver.Conn, error) {
+	if name == "down" {
+		return nil, errors.New("connection refused")
+	}
+	return pingConn{}, nil
+}
+
+type pingConn struct{}
+
+func (pingConn) Prepare(string) (sqldriver.Stmt, error) { return nil, errors.New("not supported") }
+func (pingConn) Close() error                           { return nil }
+func (pingConn) Begin() (sqldriver.Tx, error)           { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("ping", pingDriver{})
+}
+
+func openPingDB(t *testing.T, name string) *driver.DB {
+	t.Helper()
+
+	db, err := sql.Open("ping", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &driver.DB{SQL: db}
+}
+
+func TestRepository_Healthz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.Healthz).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "ok" {
+		t.Errorf("got %d %q, wanted 200 ok", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("the probe must not be cached")
+	}
+}
+
+func TestRepository_Readyz(t *testing.T) {
+	withoutTemplates := app
+	withoutTemplates.TemplateCache = nil
+
+	var tests = []struct {
+		name               string
+		repo               *Repository
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{"ready", &Repository{App: &app, DB: Repo.DB, Conn: openPingDB(t, "up")}, http.StatusOK, "ok"},
+		{"not connected", Repo, http.StatusServiceUnavailable, "database: not connected"},
+		{"database down", &Repository{App: &app, DB: Repo.DB, Conn: openPingDB(t, "down")}, http.StatusServiceUnavailable, "database: not answering"},
+		{"templates not loaded", &Repository{App: &withoutTemplates, DB: Repo.DB, Conn: openPingDB(t, "up")}, http.StatusServiceUnavailable, "templates: not loaded"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(e.repo.Readyz).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("%s: expected %q in %q", e.name, e.expectedBody, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bangn/bookings/internal/forms"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/ical"
+	"github.com/bangn/bookings/internal/icalsync"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// calendarProdID names this application in the calendars it writes
+const calendarProdID = "-//Fort Smythe Bed and Breakfast//Bookings//EN"
+
+// the room feeds cover the nights from calendarPastDays ago up to calendarFutureDays ahead
+const (
+	calendarPastDays   = 90
+	calendarFutureDays = 2 * 365
+)
+
+// calendarDomain is the right-hand side of the UIDs of the events, the host of the site
+func (m *Repository) calendarDomain() string {
+	u, err := url.Parse(m.App.BaseURL)
+	if err != nil || u.Hostname() == "" {
+		return "bookings"
+	}
+	return u.Hostname()
+}
+
+// roomCalendarURL is the address calendar apps subscribe to for the bookings of a room
+func (m *Repository) roomCalendarURL(room models.Room) string {
+	return fmt.Sprintf("%s/rooms/%d/calendar.ics?token=%s", m.App.BaseURL, room.ID, url.QueryEscape(room.CalendarToken))
+}
+
+// writeCalendar sends a calendar, as a file to download when filename is set
+func (m *Repository) writeCalendar(w http.ResponseWriter, r *http.Request, cal ical.Calendar, filename string) {
+	w.Header().Set("Content-Type", ical.ContentType)
+	if filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	_, err := cal.WriteTo(w)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "writing calendar", "error", err)
+	}
+}
+
+// RoomCalendar is the iCalendar feed of the reservations and owner blocks of a room. Calendar apps can not log in,
+// the feed is protected by the token of the room instead; a wrong token gets the same 404 as an unknown room
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if room.CalendarToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(room.CalendarToken)) != 1 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), room.ID, today.AddDate(0, 0, -calendarPastDays), today.AddDate(0, 0, calendarFutureDays))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: calendarProdID,
+		Name:   room.RoomName,
+	}
+
+	for _, rr := range restrictions {
+		// a hold lasts minutes, the other platforms would keep it long after it ended
+		if rr.IsHold() {
+			continue
+		}
+
+		event := ical.Event{
+			UID:   fmt.Sprintf("room-restriction-%d@%s", rr.ID, m.calendarDomain()),
+			Start: rr.StartDate,
+			End:   rr.EndDate,
+			Stamp: rr.UpdatedAt,
+		}
+
+		if rr.ReservationID != 0 {
+			event.Summary = fmt.Sprintf("Reserved: %s %s", rr.Reservations.FirstName, rr.Reservations.LastName)
+			event.URL = fmt.Sprintf("%s/admin/reservations/all/%d", m.App.BaseURL, rr.ReservationID)
+		} else if rr.IsImported() {
+			event.Summary = "Blocked: booked on another platform"
+		} else {
+			event.Summary = "Blocked: " + rr.Restrictions.RestrictionName
+		}
+
+		cal.Events = append(cal.Events, event)
+	}
+
+	m.writeCalendar(w, r, cal, "")
+}
+
+// GuestReservationCalendar lets the guest download the stay as an .ics file for their own calendar
+func (m *Repository) GuestReservationCalendar(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: calendarProdID,
+		Events: []ical.Event{{
+			UID:         fmt.Sprintf("reservation-%d@%s", res.ID, m.calendarDomain()),
+			Start:       res.StartDate,
+			End:         res.EndDate,
+			Summary:     "Stay at Fort Smythe Bed and Breakfast",
+			Description: fmt.Sprintf("%s for %d guest(s)", res.RoomNames(), res.Guests()),
+			URL:         fmt.Sprintf("%s/reservations/%s", m.App.BaseURL, res.Token),
+			Cancelled:   res.IsCancelled(),
+			Stamp:       res.UpdatedAt,
+		}},
+	}
+
+	m.writeCalendar(w, r, cal, fmt.Sprintf("reservation-%d.ics", res.ID))
+}
+
+// roomCalendarFeed loads the calendar feed named by the {feedID} URL parameter, it must belong to room
+func (m *Repository) roomCalendarFeed(w http.ResponseWriter, r *http.Request, room models.Room) (models.RoomCalendarFeed, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "feedID"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.RoomCalendarFeed{}, false
+	}
+
+	feed, err := m.DB.GetRoomCalendarFeedByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && feed.RoomID != room.ID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return feed, false
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return feed, false
+	}
+
+	return feed, true
+}
+
+// AdminPostRoomCalendarFeed adds the calendar feed of another platform to a room and imports its blocks
+func (m *Repository) AdminPostRoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// the room form is shown again along with the feed form
+	values := roomFormValues(room)
+	values.Set("feed_name", r.Form.Get("feed_name"))
+	values.Set("feed_url", strings.TrimSpace(r.Form.Get("feed_url")))
+	form := forms.New(values)
+	form.Required("feed_name", "feed_url")
+	if u := form.Get("feed_url"); u != "" && !icalsync.IsRemote(u) && !filepath.IsAbs(u) {
+		form.Errors.Add("feed_url", "Enter an http(s) address, or the absolute path of a file on the server")
+	}
+
+	if !form.Valid() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	feed := models.RoomCalendarFeed{
+		RoomID: room.ID,
+		Name:   form.Get("feed_name"),
+		URL:    form.Get("feed_url"),
+	}
+	feed.ID, err = m.DB.InsertRoomCalendarFeed(r.Context(), feed)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.syncRoomCalendarFeed(w, r, feed)
+}
+
+// AdminSyncRoomCalendarFeed imports the blocks of a calendar feed now, rather than waiting for the background sync
+func (m *Repository) AdminSyncRoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	feed, ok := m.roomCalendarFeed(w, r, room)
+	if !ok {
+		return
+	}
+
+	m.syncRoomCalendarFeed(w, r, feed)
+}
+
+// syncRoomCalendarFeed imports the blocks of a feed and goes back to its room with the outcome
+func (m *Repository) syncRoomCalendarFeed(w http.ResponseWriter, r *http.Request, feed models.RoomCalendarFeed) {
+	result, err := icalsync.New(m.DB).SyncFeed(r.Context(), feed)
+	switch {
+	case err != nil:
+		m.App.Logger.ErrorContext(r.Context(), "syncing calendar feed", "feed_id", feed.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The calendar %s could not be imported: %v", feed.Name, err))
+	case result.Conflicts > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Calendar %s imported, %d of its bookings overlap reservations made here, the room is double booked",
+			feed.Name, result.Conflicts))
+	default:
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Calendar %s imported: %d block(s) added, %d updated, %d removed",
+			feed.Name, result.Added, result.Updated, result.Removed))
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", feed.RoomID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomCalendarFeed stops importing a calendar feed, the blocks it made are removed with it
+func (m *Repository) AdminDeleteRoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	feed, ok := m.roomCalendarFeed(w, r, room)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteRoomCalendarFeed(r.Context(), feed.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Calendar %s removed along with its blocks", feed.Name))
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var roomCalendarTests = []struct {
+	name               string
+	roomID             string
+	token              string
+	expectedStatusCode int
+}{
+	{"valid token", "1", "generals-calendar-token", http.StatusOK},
+	{"token of another room", "1", "majors-calendar-token", http.StatusNotFound},
+	{"missing token", "1", "", http.StatusNotFound},
+	{"unknown room", "2000", "generals-calendar-token", http.StatusNotFound},
+	{"malformed room id", "one", "generals-calendar-token", http.StatusBadRequest},
+}
+
+func TestRepository_RoomCalendar(t *testing.T) {
+	for _, e := range roomCalendarTests {
+		req, _ := http.NewRequest("GET", "/rooms/"+e.roomID+"/calendar.ics?token="+e.token, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.RoomCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		body := rr.Body.String()
+		if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+			t.Errorf("%s: got content type %q", e.name, rr.Header().Get("Content-Type"))
+		}
+		// the test repo has a reservation, an owner block, an imported block and a hold for the room, holds are not exported
+		if strings.Count(body, "BEGIN:VEVENT") != 3 || !strings.Contains(body, "SUMMARY:Reserved: John Smith") ||
+			!strings.Contains(body, "SUMMARY:Blocked: booked on another platform") {
+			t.Errorf("%s: unexpected feed\n%s", e.name, body)
+		}
+	}
+}
+
+func TestRepository_GuestReservationCalendar(t *testing.T) {
+	for token, expectedStatusCode := range map[string]int{"valid-token": http.StatusOK, "unknown-token": http.StatusNotFound} {
+		req, _ := http.NewRequest("GET", "/reservations/"+token+"/calendar.ics", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestReservationCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", token, rr.Code, expectedStatusCode)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		if !strings.HasPrefix(rr.Header().Get("Content-Disposition"), "attachment;") {
+			t.Errorf("%s: the calendar is not sent as a file to download", token)
+		}
+		res, _ := Repo.DB.GetReservationByToken(context.Background(), token)
+		expected := "DTSTART;VALUE=DATE:" + res.StartDate.Format("20060102") + "\r\nDTEND;VALUE=DATE:" + res.EndDate.Format("20060102")
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("%s: expected %q in\n%s", token, expected, rr.Body.String())
+		}
+	}
+}
+
+func TestRepository_AdminResetRoomCalendarToken(t *testing.T) {
+	for id, expectedStatusCode := range map[string]int{"1": http.StatusSeeOther, "2000": http.StatusNotFound} {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+id+"/calendar-token", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminResetRoomCalendarToken)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != expectedStatusCode {
+			t.Errorf("room %s: got status %d, wanted %d", id, rr.Code, expectedStatusCode)
+		}
+	}
+}
+
+var adminRoomCalendarFeedTests = []struct {
+	name               string
+	roomID             string
+	feedID             string
+	action             string
+	expectedStatusCode int
+	expectedFlash      string
+}{
+	{"sync", "1", "1", "sync", http.StatusSeeOther, "flash"},
+	{"sync of a broken feed", "1", "2", "sync", http.StatusSeeOther, "error"},
+	{"sync of an unknown feed", "1", "9", "sync", http.StatusNotFound, ""},
+	{"sync of the feed of another room", "2", "1", "sync", http.StatusNotFound, ""},
+	{"sync with a malformed feed id", "1", "one", "sync", http.StatusBadRequest, ""},
+	{"delete", "1", "1", "delete", http.StatusSeeOther, "flash"},
+	{"delete an unknown feed", "1", "9", "delete", http.StatusNotFound, ""},
+	{"delete in an unknown room", "2000", "1", "delete", http.StatusNotFound, ""},
+}
+
+func TestRepository_AdminRoomCalendarFeed(t *testing.T) {
+	for _, e := range adminRoomCalendarFeedTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/feeds/"+e.feedID+"/"+e.action, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		rctx.URLParams.Add("feedID", e.feedID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminSyncRoomCalendarFeed)
+		if e.action == "delete" {
+			handler = Repo.AdminDeleteRoomCalendarFeed
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, e.expectedFlash) == "" {
+			t.Errorf("%s: no %s message put in session", e.name, e.expectedFlash)
+		}
+	}
+}
+
+// adminPostRoomCalendarFeedTests add feeds served by a test server, whose address replaces SERVER
+var adminPostRoomCalendarFeedTests = []struct {
+	name               string
+	roomID             string
+	feedName           string
+	feedURL            string
+	expectedStatusCode int
+}{
+	{"http address", "1", "Airbnb", "SERVER/airbnb.ics", http.StatusSeeOther},
+	{"absolute path", "1", "Booking.com", "/var/lib/bookings/room-1.ics", http.StatusSeeOther},
+	{"relative path", "1", "Booking.com", "room-1.ics", http.StatusUnprocessableEntity},
+	{"other scheme", "1", "Booking.com", "ftp://example.com/room-1.ics", http.StatusUnprocessableEntity},
+	{"missing name", "1", "", "SERVER/airbnb.ics", http.StatusUnprocessableEntity},
+	{"missing address", "1", "Airbnb", "", http.StatusUnprocessableEntity},
+	{"unknown room", "2000", "Airbnb", "SERVER/airbnb.ics", http.StatusNotFound},
+}
+
+func TestRepository_AdminPostRoomCalendarFeed(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir("../icalsync/testdata")))
+	defer srv.Close()
+
+	for _, e := range adminPostRoomCalendarFeedTests {
+		postedData := url.Values{}
+		postedData.Add("feed_name", e.feedName)
+		postedData.Add("feed_url", strings.Replace(e.feedURL, "SERVER", srv.URL, 1))
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/feeds", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomCalendarFeed)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/rooms/"+e.roomID {
+			t.Errorf("%s: redirected to %q", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
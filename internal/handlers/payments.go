@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/metrics"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/payments"
+	"github.com/bangn/bookings/internal/repository"
+)
+
+// maxWebhookBodyBytes limits the size of payment webhook requests
+const maxWebhookBodyBytes = 64 << 10
+
+// errNoPaymentProvider is returned for payments asked for when no payment is taken online
+var errNoPaymentProvider = errors.New("no payment provider is set up")
+
+// bookingStatus is the status a reservation of total is booked with. It waits for its payment when there is
+// something to pay and a payment provider to take it, otherwise it is left new for staff to confirm
+func (m *Repository) bookingStatus(total int) string {
+	if total == 0 || m.App.Payments == nil {
+		return models.ReservationNew
+	}
+	// the room is held until the stay is paid, see ExpirePendingReservations
+	return models.ReservationPendingPayment
+}
+
+// paymentIntent returns the payment intent of a reservation waiting for its payment, creating it on first use
+func (m *Repository) paymentIntent(ctx context.Context, res models.Reservation) (string, error) {
+	if res.PaymentIntentID != "" {
+		return res.PaymentIntentID, nil
+	}
+	if m.App.Payments == nil {
+		return "", errNoPaymentProvider
+	}
+
+	intent, err := m.App.Payments.CreateIntent(res.Total, res.Currency, strconv.Itoa(res.ID))
+	if err != nil {
+		return "", err
+	}
+
+	err = m.DB.SetReservationPaymentIntent(ctx, res.ID, intent.ID)
+	if err != nil {
+		return "", err
+	}
+	return intent.ID, nil
+}
+
+// confirmPayment confirms a paid reservation and sends the confirmation mails, it reports false when the
+// reservation was not waiting for its payment. Payments reported twice, by the guest's request and by the webhook,
+// only send them once
+func (m *Repository) confirmPayment(ctx context.Context, id int) (bool, error) {
+	confirmed, err := m.DB.ConfirmReservationPayment(ctx, id)
+	if err != nil || !confirmed {
+		return false, err
+	}
+	metrics.PaymentsConfirmed.Inc()
+
+	res, err := m.DB.GetReservationByID(ctx, id)
+	if err != nil {
+		return true, err
+	}
+
+	m.sendReservationMails(res)
+	return true, nil
+}
+
+// errPaymentMismatch is returned by settlePayment for a payment of another amount, or currency, than the total
+// of its reservation
+var errPaymentMismatch = errors.New("payment does not match the total of the reservation")
+
+// settlePayment confirms the reservation the payment of intent was received for. A payment that is not of the
+// total of the reservation, in its currency, is left for staff to look at and errPaymentMismatch is returned.
+// When the reservation expired, or was cancelled, before the payment arrived the payment is refunded instead
+// and false is returned
+func (m *Repository) settlePayment(ctx context.Context, id int, intent payments.Intent) (bool, error) {
+	res, err := m.DB.GetReservationByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if intent.Amount != res.Total || !strings.EqualFold(intent.Currency, res.Currency) {
+		return false, fmt.Errorf("%w: intent %s is of %d %s, reservation %d of %d %s",
+			errPaymentMismatch, intent.ID, intent.Amount, intent.Currency, res.ID, res.Total, res.Currency)
+	}
+
+	confirmed, err := m.confirmPayment(ctx, id)
+	if err != nil || confirmed {
+		return confirmed, err
+	}
+
+	res, err = m.DB.GetReservationByID(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if res.Status != models.ReservationCancelled {
+		// confirmed already, the payment was reported twice
+		return true, nil
+	}
+
+	res.PaymentIntentID = intent.ID
+	return false, m.refundPayment(ctx, res, models.ActorPayments)
+}
+
+// refundPayment pays a reservation back and cancels it, the status is checked first so nothing
+// is paid back for a reservation that can not be refunded
+func (m *Repository) refundPayment(ctx context.Context, res models.Reservation, actor models.Actor) error {
+	if !models.CanTransition(res.Status, models.ReservationRefunded) {
+		return fmt.Errorf("%w: %s to %s", repository.ErrInvalidStatusTransition, res.Status, models.ReservationRefunded)
+	}
+	if m.App.Payments == nil {
+		return errNoPaymentProvider
+	}
+
+	_, err := m.App.Payments.Refund(res.PaymentIntentID)
+	if err != nil {
+		return err
+	}
+	return m.DB.RefundReservation(ctx, res.ID, actor)
+}
+
+// PostGuestPayReservation takes the payment of a reservation waiting for it
+func (m *Repository) PostGuestPayReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	link := fmt.Sprintf("/reservations/%s", res.Token)
+
+	if !res.IsPendingPayment() {
+		m.App.Session.Put(r.Context(), "warning", "There is nothing left to pay for this reservation")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+	if m.App.Payments == nil {
+		m.App.Session.Put(r.Context(), "error", "Sorry, payments are not taken online. Please contact us to pay for your reservation.")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	intentID, err := m.paymentIntent(r.Context(), res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	intent, err := m.App.Payments.Confirm(intentID)
+	if err != nil || intent.Status != payments.StatusSucceeded {
+		m.App.Session.Put(r.Context(), "error", "Sorry, your payment did not go through. Please try again.")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	// the money is taken, the guest going away must not stop the reservation from being confirmed or refunded
+	confirmed, err := m.settlePayment(context.WithoutCancel(r.Context()), res.ID, intent)
+	if errors.Is(err, errPaymentMismatch) {
+		m.App.Logger.ErrorContext(r.Context(), "payment does not match the reservation", "reservation_id", res.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", "Sorry, your payment does not match the total of your reservation. We will contact you to sort it out.")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !confirmed {
+		m.App.Session.Put(r.Context(), "error", "Sorry, your reservation expired before your payment went through. The payment was refunded, please book again.")
+		http.Redirect(w, r, link, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Thank you, your payment was received and your reservation is confirmed")
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// PaymentWebhook receives the events of the payment provider. It is exempt from CSRF checks,
+// the provider signs its requests instead
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if m.App.Payments == nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	event, err := m.App.Payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		m.App.Logger.WarnContext(r.Context(), "rejected payment webhook", "error", err)
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	res, err := m.DB.GetReservationByPaymentIntent(r.Context(), event.Intent.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// not ours to handle, answering an error would only make the provider send it again
+		m.App.Logger.InfoContext(r.Context(), "ignored payment webhook for unknown intent", "event_id", event.ID, "intent_id", event.Intent.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	switch event.Type {
+	case payments.EventSucceeded:
+		_, err = m.settlePayment(r.Context(), res.ID, event.Intent)
+	case payments.EventRefunded:
+		if res.Status != models.ReservationRefunded {
+			err = m.DB.RefundReservation(r.Context(), res.ID, models.ActorPayments)
+		}
+	}
+	if errors.Is(err, repository.ErrInvalidStatusTransition) || errors.Is(err, errPaymentMismatch) {
+		// the event does not apply to the reservation, staff have to look at it
+		m.App.Logger.ErrorContext(r.Context(), "payment webhook does not apply to the reservation", "event_id", event.ID, "reservation_id", res.ID, "error", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
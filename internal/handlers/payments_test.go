@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/payments"
+	"github.com/bangn/bookings/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+var guestPayTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expectedFlash      string
+}{
+	{"pending reservation", "pending-token", http.StatusSeeOther, "flash"},
+	{"reservation expired while paying", "expiring-token", http.StatusSeeOther, "error"},
+	{"confirmed reservation", "valid-token", http.StatusSeeOther, "warning"},
+	{"cancelled reservation", "cancelled-token", http.StatusSeeOther, "warning"},
+	{"unknown token", "unknown-token", http.StatusNotFound, ""},
+}
+
+func TestRepository_PostGuestPayReservation(t *testing.T) {
+	for _, e := range guestPayTests {
+		req, _ := http.NewRequest("POST", "/reservations/"+e.token+"/pay", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostGuestPayReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, e.expectedFlash) == "" {
+			t.Errorf("%s: expected a %s message in session", e.name, e.expectedFlash)
+		}
+		if e.expectedFlash != "flash" && session.GetString(ctx, "flash") != "" {
+			t.Errorf("%s: the guest is told the reservation is confirmed", e.name)
+		}
+	}
+}
+
+var paymentWebhookTests = []struct {
+	name               string
+	payload            string
+	signed             bool
+	expectedStatusCode int
+}{
+	{"payment received", `{"id":"evt_1","type":"payment_intent.succeeded","intent":{"id":"fake_pi_pending","amount":24000,"currency":"USD"}}`, true, http.StatusOK},
+	{"refund", `{"id":"evt_2","type":"charge.refunded","intent":{"id":"fake_pi_pending","amount":24000,"currency":"USD"}}`, true, http.StatusOK},
+	{"unknown intent", `{"id":"evt_3","type":"payment_intent.succeeded","intent":{"id":"fake_pi_unknown"}}`, true, http.StatusOK},
+	{"not signed", `{"id":"evt_4","type":"payment_intent.succeeded","intent":{"id":"fake_pi_pending","amount":24000,"currency":"USD"}}`, false, http.StatusBadRequest},
+	{"malformed event", `{"id":`, true, http.StatusBadRequest},
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	provider := app.Payments.(*payments.FakeProvider)
+
+	for _, e := range paymentWebhookTests {
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(e.payload))
+		if e.signed {
+			req.Header.Set(payments.FakeSignatureHeader, provider.Sign([]byte(e.payload)))
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// confirmRecordingRepo is the test repository recording the reservations whose payment is confirmed
+type confirmRecordingRepo struct {
+	repository.DatabaseRepo
+	confirmed []int
+}
+
+func (m *confirmRecordingRepo) ConfirmReservationPayment(ctx context.Context, id int) (bool, error) {
+	m.confirmed = append(m.confirmed, id)
+	return m.DatabaseRepo.ConfirmReservationPayment(ctx, id)
+}
+
+var paymentAmountTests = []struct {
+	name              string
+	amount            int
+	currency          string
+	expectedConfirmed bool
+}{
+	{"total paid", 24000, "usd", true},
+	{"part of the total paid", 12000, "USD", false},
+	{"total paid in another currency", 24000, "EUR", false},
+}
+
+func TestRepository_PaymentWebhook_ChecksAmount(t *testing.T) {
+	provider := app.Payments.(*payments.FakeProvider)
+
+	for _, e := range paymentAmountTests {
+		db := &confirmRecordingRepo{DatabaseRepo: Repo.DB}
+		repo := &Repository{App: &app, DB: db}
+
+		payload := fmt.Sprintf(`{"id":"evt_1","type":"payment_intent.succeeded","intent":{"id":"fake_pi_pending","amount":%d,"currency":%q}}`, e.amount, e.currency)
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(payload))
+		req.Header.Set(payments.FakeSignatureHeader, provider.Sign([]byte(payload)))
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, http.StatusOK)
+		}
+		if confirmed := len(db.confirmed) > 0; confirmed != e.expectedConfirmed {
+			t.Errorf("%s: reservation confirmed %v, wanted %v", e.name, confirmed, e.expectedConfirmed)
+		}
+	}
+}
+
+func TestRepository_AdminRefundReservation(t *testing.T) {
+	for id, expected := range map[string]int{"1": http.StatusSeeOther, "2000": http.StatusNotFound, "x": http.StatusBadRequest} {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+id+"/refund", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminRefundReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("refund of reservation %s: got status %d, wanted %d", id, rr.Code, expected)
+		}
+	}
+}
+
+func TestRepository_WithoutPaymentProvider(t *testing.T) {
+	provider := app.Payments
+	app.Payments = nil
+	defer func() { app.Payments = provider }()
+
+	if status := Repo.bookingStatus(24000); status != models.ReservationNew {
+		t.Errorf("without a payment provider reservations are left new for staff, got %q", status)
+	}
+
+	req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(paymentWebhookTests[0].payload))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("webhook without a payment provider: got status %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	req, _ = http.NewRequest("POST", "/reservations/pending-token/pay", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", "pending-token")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostGuestPayReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther || session.GetString(ctx, "error") == "" {
+		t.Errorf("paying without a payment provider: got status %d, wanted %d and an error message", rr.Code, http.StatusSeeOther)
+	}
+}
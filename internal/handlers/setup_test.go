@@ -1,19 +1,21 @@
 package handlers
 
 import (
-	"encoding/gob"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
-	"text/template"
+	"html/template"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/helpers"
+	"github.com/bangn/bookings/internal/logging"
 	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/payments"
 	"github.com/bangn/bookings/internal/render"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -23,18 +25,16 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate": render.HumanDate,
+	"money":     render.Money,
+}
 
 func TestMain(m *testing.M) {
-	gob.Register(models.Reservation{})
 
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "[INFO]\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "[ERROR]\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logging.New(os.Stdout, app.InProduction)
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -44,6 +44,14 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
+	mailChan := make(chan models.MailData, 100)
+	app.MailChan = mailChan
+	listenForMail()
+
+	app.Payments = payments.NewFakeProvider("test-secret")
+	app.PaymentTimeout = 30 * time.Minute
+	app.BookingHold = 15 * time.Minute
+
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("Can not creae template cache")
@@ -58,14 +66,23 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 	
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
+// listenForMail drains the mail channel, so handlers never block on it in tests
+func listenForMail() {
+	go func() {
+		for range app.MailChan {
+		}
+	}()
+}
+
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
 	
-
+	mux.Use(middleware.RequestID)
 	mux.Use(middleware.Recoverer)
 
 	// protect against CSRF attacks
@@ -76,16 +93,75 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about",   Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/rooms/{id}/calendar.ics", Repo.RoomCalendar)
+	// the rooms used to have hand-written pages
+	mux.Method("GET", "/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Method("GET", "/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
-	mux.Get("/make-reservation", Repo.Reservation)
-	mux.Post("/make-reservation", Repo.PostReservation)
-	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/book-room", Repo.BookRoom)
+	mux.Get("/bookings/{draft}/choose-room/{id}", Repo.ChooseRoom)
+	mux.Get("/bookings/{draft}/choose-rooms", Repo.ChooseRooms)
+	mux.Get("/bookings/{draft}", Repo.Reservation)
+	mux.Post("/bookings/{draft}", Repo.PostReservation)
+	mux.Get("/bookings/{draft}/summary", Repo.ReservationSummary)
+	mux.Get("/make-reservation", Repo.ResumeBooking)
+	mux.Get("/reservation-summary", Repo.ResumeBooking)
+	mux.Get("/reservations/{token}", Repo.GuestReservation)
+	mux.Post("/reservations/{token}/change", Repo.PostGuestChangeReservation)
+	mux.Post("/reservations/{token}/cancel", Repo.PostGuestCancelReservation)
+	mux.Post("/reservations/{token}/pay", Repo.PostGuestPayReservation)
+	mux.Get("/reservations/{token}/calendar.ics", Repo.GuestReservationCalendar)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
 	mux.Get("/contact", Repo.Contact)
-	
+
+	mux.Get("/user/login", Repo.ShowLogin)
+	mux.Post("/user/login", Repo.PostLogin)
+	mux.Get("/user/logout", Repo.Logout)
+
+	// versioned JSON API
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+
+
+		// every endpoint needs an API key, reading reservations needs an admin one
+		mux.Use(Repo.APIAuth)
+
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/rooms/{id}/availability", Repo.APIRoomAvailability)
+		mux.Get("/rooms/{id}/quote", Repo.APIRoomQuote)
+		mux.Post("/reservations", Repo.APIPostReservation)
+		mux.With(Repo.APIScope(models.AccessLevelAdmin)).Get("/reservations/{id}", Repo.APIGetReservation)
+	})
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Get("/dashboard", Repo.AdminDashboard)
+		mux.Get("/reservations-new", Repo.AdminNewReservations)
+		mux.Get("/reservations-all", Repo.AdminAllReservations)
+		mux.Get("/reservations/{src}/{id}", Repo.AdminShowReservation)
+		mux.Post("/reservations/{src}/{id}/process", Repo.AdminProcessReservation)
+		mux.Post("/reservations/{src}/{id}/refund", Repo.AdminRefundReservation)
+		mux.Post("/reservations/{src}/{id}/status", Repo.AdminPostReservationStatus)
+		mux.Get("/reservations-calendar", Repo.AdminReservationsCalendar)
+		mux.Post("/reservations-calendar", Repo.AdminPostReservationsCalendar)
+		mux.Get("/rooms", Repo.AdminRooms)
+		mux.Get("/rooms/new", Repo.AdminNewRoom)
+		mux.Post("/rooms/new", Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", Repo.AdminPostRoom)
+		mux.Post("/rooms/{id}/delete", Repo.AdminDeleteRoom)
+		mux.Post("/rooms/{id}/calendar-token", Repo.AdminResetRoomCalendarToken)
+		mux.Post("/rooms/{id}/feeds", Repo.AdminPostRoomCalendarFeed)
+		mux.Post("/rooms/{id}/feeds/{feedID}/sync", Repo.AdminSyncRoomCalendarFeed)
+		mux.Post("/rooms/{id}/feeds/{feedID}/delete", Repo.AdminDeleteRoomCalendarFeed)
+		mux.Get("/api-keys", Repo.AdminAPIKeys)
+		mux.Post("/api-keys", Repo.AdminPostAPIKey)
+		mux.Post("/api-keys/{id}/revoke", Repo.AdminRevokeAPIKey)
+	})
 	
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
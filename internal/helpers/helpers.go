@@ -1,7 +1,10 @@
 package helpers
 
 import (
-	"fmt"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
 	"runtime/debug"
 
@@ -15,15 +18,40 @@ func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-// ClientError is a helper function to send (http) client error messages
+// ClientError is a helper function to send (http) client error messages, the access log records their status
 func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
 	http.Error(w, http.StatusText(status), status)
 }
 
 // ServerError is a helper function to send server (internal app's) error messages
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	LogServerError(r, err)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-}
\ No newline at end of file
+}
+// LogServerError logs err together with the stack trace of the caller, under the request ID of r
+func LogServerError(r *http.Request, err error) {
+	app.Logger.ErrorContext(r.Context(), "server error",
+		"method", r.Method, "path", r.URL.Path, "error", err, "stack", string(debug.Stack()))
+}
+
+// IsAuthenticated reports whether the session of the request belongs to a logged in user
+func IsAuthenticated(r *http.Request) bool {
+	return app.Session.Exists(r.Context(), "user_id")
+}
+
+
+// RandomToken returns an unguessable, URL safe token made of n random bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA-256 hex digest of a random token, for storing tokens we must be able to look up
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
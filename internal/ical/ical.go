@@ -0,0 +1,142 @@
+// Package ical writes iCalendar (RFC 5545) feeds of all-day events, the format calendar apps subscribe to
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentType is the media type of an iCalendar file
+const ContentType = "text/calendar; charset=utf-8"
+
+const (
+	// dateLayout formats the DATE values of all-day events
+	dateLayout = "20060102"
+	// stampLayout formats the UTC DATE-TIME values
+	stampLayout = "20060102T150405Z"
+	// maxLineOctets is the longest a content line may be before it is folded
+	maxLineOctets = 75
+)
+
+// Calendar is a feed of events
+type Calendar struct {
+	// ProdID names the product that wrote the calendar, e.g. -//Fort Smythe//Bookings//EN
+	ProdID string
+	// Name is shown by calendar apps as the name of a subscribed calendar
+	Name   string
+	Events []Event
+}
+
+// Event is an all-day event lasting from Start up to, not including, End.
+// Only the dates of Start and End are written, the way the date columns of the database store stays
+type Event struct {
+	// UID identifies the event across updates of the feed, it must not change when the event moves
+	UID         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	URL         string
+	Cancelled   bool
+	// Stamp is when the event was last changed, the time of writing when zero
+	Stamp time.Time
+}
+
+// WriteTo writes the calendar to w
+func (c Calendar) WriteTo(w io.Writer) (int64, error) {
+	cw := &contentWriter{w: bufio.NewWriter(w)}
+	now := time.Now()
+
+	cw.line("BEGIN", "VCALENDAR")
+	cw.line("VERSION", "2.0")
+	cw.line("PRODID", c.ProdID)
+	cw.line("CALSCALE", "GREGORIAN")
+	cw.line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		cw.line("X-WR-CALNAME", escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Stamp
+		if stamp.IsZero() {
+			stamp = now
+		}
+
+		cw.line("BEGIN", "VEVENT")
+		cw.line("UID", e.UID)
+		cw.line("DTSTAMP", stamp.UTC().Format(stampLayout))
+		cw.line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		cw.line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		cw.line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			cw.line("DESCRIPTION", escape(e.Description))
+		}
+		if e.URL != "" {
+			cw.line("URL", e.URL)
+		}
+		if e.Cancelled {
+			cw.line("STATUS", "CANCELLED")
+		}
+		cw.line("TRANSP", "OPAQUE")
+		cw.line("END", "VEVENT")
+	}
+
+	cw.line("END", "VCALENDAR")
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// contentWriter writes content lines, keeping the first error
+type contentWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// line writes one property, folded to lines of at most maxLineOctets octets
+func (cw *contentWriter) line(name, value string) {
+	if cw.err != nil {
+		return
+	}
+
+	for _, l := range fold(name + ":" + value) {
+		n, err := cw.w.WriteString(l + "\r\n")
+		cw.n += int64(n)
+		if err != nil {
+			cw.err = err
+			return
+		}
+	}
+}
+
+// fold splits a content line into lines of at most maxLineOctets octets, without cutting a character in two.
+// Every line after the first starts with the space that marks it as a continuation
+func fold(s string) []string {
+	var lines []string
+
+	limit := maxLineOctets
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		lines = append(lines, s[:cut])
+		s = " " + s[cut:]
+	}
+
+	return append(lines, s)
+}
+
+// textEscaper escapes the characters TEXT values can not hold as they are
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escape turns s into a TEXT value
+func escape(s string) string {
+	return textEscaper.Replace(s)
+}
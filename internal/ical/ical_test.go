@@ -0,0 +1,85 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestCalendar_WriteTo(t *testing.T) {
+	cal := Calendar{
+		ProdID: "-//Test//Bookings//EN",
+		Name:   "General's Quarters",
+		Events: []Event{
+			{
+				UID:     "room-restriction-1@example.com",
+				Start:   date("2050-01-01"),
+				End:     date("2050-01-03"),
+				Summary: "Reserved; Smith, John",
+				Stamp:   time.Date(2049, time.December, 1, 10, 30, 0, 0, time.UTC),
+			},
+			{
+				UID:         "reservation-2@example.com",
+				Start:       date("2050-02-27"),
+				End:         date("2050-03-01"),
+				Summary:     "Stay",
+				Description: strings.Repeat("é", 50) + "\nsecond line",
+				Cancelled:   true,
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	n, err := cal.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("reported %d bytes written, wrote %d", n, buf.Len())
+	}
+
+	out := buf.String()
+	if !strings.HasSuffix(out, "END:VCALENDAR\r\n") {
+		t.Error("lines must end with CRLF")
+	}
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//Bookings//EN\r\n",
+		"X-WR-CALNAME:General's Quarters\r\n",
+		"DTSTAMP:20491201T103000Z\r\n",
+		// all-day events end on the day after their last night
+		"DTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500103\r\n",
+		"DTSTART;VALUE=DATE:20500227\r\nDTEND;VALUE=DATE:20500301\r\n",
+		`SUMMARY:Reserved\; Smith\, John` + "\r\n",
+		"STATUS:CANCELLED\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in\n%s", expected, out)
+		}
+	}
+
+	if strings.Count(out, "BEGIN:VEVENT") != 2 {
+		t.Errorf("expected 2 events in\n%s", out)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Errorf("line of %d octets is not folded: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("folding cut a character in two: %q", line)
+		}
+	}
+
+	unfolded := strings.ReplaceAll(out, "\r\n ", "")
+	if !strings.Contains(unfolded, "DESCRIPTION:"+strings.Repeat("é", 50)+`\nsecond line`+"\r\n") {
+		t.Errorf("the description does not unfold to its value in\n%s", out)
+	}
+}
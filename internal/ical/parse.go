@@ -0,0 +1,233 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned by Parse for input that is not an iCalendar file
+var ErrNotCalendar = errors.New("ical: not an iCalendar file")
+
+// maxLineBytes is the longest unfolded line Parse accepts
+const maxLineBytes = 1 << 20
+
+// property is one content line, NAME;PARAM=VALUE:value
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the events of an iCalendar file, as much of them as blocking a room needs: the UID, the dates,
+// the summary and whether the event is cancelled. Times are turned into the dates they fall on in their own
+// time zone, recurrence rules are not expanded
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	var events []Event
+	var event *Event
+	var duration string
+	// components holds the components the current line is nested in, alarms live inside events
+	var components []string
+
+	for i, line := range lines {
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("ical: line %d: %w", i+1, err)
+		}
+
+		switch p.name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(p.value))
+			if len(components) == 2 && components[1] == "VEVENT" {
+				event = &Event{}
+				duration = ""
+			}
+			continue
+		case "END":
+			if len(components) == 2 && components[1] == "VEVENT" {
+				if err := finishEvent(event, duration); err != nil {
+					return nil, fmt.Errorf("ical: event ending on line %d: %w", i+1, err)
+				}
+				events = append(events, *event)
+				event = nil
+			}
+			if len(components) > 0 {
+				components = components[:len(components)-1]
+			}
+			continue
+		}
+
+		if event == nil || len(components) != 2 {
+			continue
+		}
+
+		switch p.name {
+		case "UID":
+			event.UID = p.value
+		case "SUMMARY":
+			event.Summary = unescape(p.value)
+		case "DESCRIPTION":
+			event.Description = unescape(p.value)
+		case "URL":
+			event.URL = p.value
+		case "STATUS":
+			event.Cancelled = strings.EqualFold(p.value, "CANCELLED")
+		case "DTSTART":
+			event.Start, err = parseDate(p)
+		case "DTEND":
+			event.End, err = parseDate(p)
+		case "DURATION":
+			duration = p.value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ical: line %d: %w", i+1, err)
+		}
+	}
+
+	return events, nil
+}
+
+// finishEvent checks an event once all its properties are read, and works out its end when it has none
+func finishEvent(e *Event, duration string) error {
+	if e.UID == "" {
+		return errors.New("missing UID")
+	}
+	if e.Start.IsZero() {
+		return errors.New("missing DTSTART")
+	}
+	if !e.End.IsZero() {
+		return nil
+	}
+
+	if duration == "" {
+		// an all-day event without an end lasts one day
+		e.End = e.Start.AddDate(0, 0, 1)
+		return nil
+	}
+
+	days, err := parseDays(duration)
+	if err != nil {
+		return err
+	}
+	e.End = e.Start.AddDate(0, 0, days)
+	return nil
+}
+
+// unfold reads the content lines of r, joining the lines folded by the writer
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineBytes)
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseProperty splits a content line into its name, parameters and value.
+// Parameter values may be quoted, and may then hold the colons and semicolons that otherwise end them
+func parseProperty(line string) (property, error) {
+	p := property{params: map[string]string{}}
+
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return p, fmt.Errorf("malformed content line %q", line)
+	}
+
+	p.value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+
+	return p, nil
+}
+
+// parseDate reads a DATE or DATE-TIME value as the date it falls on, at midnight UTC like the date columns of the database
+func parseDate(p property) (time.Time, error) {
+	if p.params["VALUE"] == "DATE" || len(p.value) == len(dateLayout) {
+		return time.Parse(dateLayout, p.value)
+	}
+
+	var t time.Time
+	var err error
+	if strings.HasSuffix(p.value, "Z") {
+		t, err = time.Parse(stampLayout, p.value)
+	} else {
+		loc := time.UTC
+		if tzid := p.params["TZID"]; tzid != "" {
+			if l, err := time.LoadLocation(tzid); err == nil {
+				loc = l
+			}
+		}
+		t, err = time.ParseInLocation(strings.TrimSuffix(stampLayout, "Z"), p.value, loc)
+	}
+	if err != nil {
+		return t, err
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// parseDays reads a DURATION of whole days or weeks, P3D or P1W
+func parseDays(duration string) (int, error) {
+	d := strings.TrimPrefix(strings.ToUpper(duration), "+")
+	if len(d) < 3 || d[0] != 'P' {
+		return 0, fmt.Errorf("unsupported duration %q", duration)
+	}
+
+	n, err := strconv.Atoi(d[1 : len(d)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("unsupported duration %q", duration)
+	}
+
+	switch d[len(d)-1] {
+	case 'D':
+		return n, nil
+	case 'W':
+		return 7 * n, nil
+	}
+	return 0, fmt.Errorf("unsupported duration %q", duration)
+}
+
+// textUnescaper reverses textEscaper
+var textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// unescape turns a TEXT value back into plain text
+func unescape(s string) string {
+	return textUnescaper.Replace(s)
+}
@@ -0,0 +1,95 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse_RoundTrip(t *testing.T) {
+	written := Calendar{
+		ProdID: "-//Test//Bookings//EN",
+		Events: []Event{
+			{UID: "a@example.com", Start: date("2050-01-01"), End: date("2050-01-03"), Summary: "Reserved; Smith, John"},
+			{UID: "b@example.com", Start: date("2050-02-27"), End: date("2050-03-01"), Summary: "Stay",
+				Description: strings.Repeat("é", 50) + "\nsecond line", Cancelled: true},
+		},
+	}
+
+	var buf bytes.Buffer
+	if _, err := written.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(written.Events) {
+		t.Fatalf("got %d events, wanted %d", len(events), len(written.Events))
+	}
+
+	for i, e := range events {
+		w := written.Events[i]
+		if e.UID != w.UID || !e.Start.Equal(w.Start) || !e.End.Equal(w.End) ||
+			e.Summary != w.Summary || e.Description != w.Description || e.Cancelled != w.Cancelled {
+			t.Errorf("event %d: got %+v, wanted %+v", i, e, w)
+		}
+	}
+}
+
+var parseTests = []struct {
+	name          string
+	event         string
+	expectedStart string
+	expectedEnd   string
+}{
+	{"dates", "DTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500104", "2050-01-01", "2050-01-04"},
+	{"utc times", "DTSTART:20500101T150000Z\r\nDTEND:20500104T100000Z", "2050-01-01", "2050-01-04"},
+	// 23:00 in New York is already the next day in UTC, the date is the one of the time zone of the event
+	{"local times", "DTSTART;TZID=America/New_York:20500101T230000\r\nDTEND;TZID=\"America/New_York\":20500103T110000", "2050-01-01", "2050-01-03"},
+	{"duration", "DTSTART;VALUE=DATE:20500101\r\nDURATION:P2D", "2050-01-01", "2050-01-03"},
+	{"no end", "DTSTART;VALUE=DATE:20500101", "2050-01-01", "2050-01-02"},
+}
+
+func TestParse(t *testing.T) {
+	for _, e := range parseTests {
+		input := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:1\r\n" + e.event +
+			"\r\nBEGIN:VALARM\r\nDESCRIPTION:not the event\r\nEND:VALARM\r\nSUMMARY:Not\r\n  available\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+		events, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if len(events) != 1 {
+			t.Errorf("%s: got %d events", e.name, len(events))
+			continue
+		}
+
+		ev := events[0]
+		if !ev.Start.Equal(date(e.expectedStart)) || !ev.End.Equal(date(e.expectedEnd)) {
+			t.Errorf("%s: got %s - %s, wanted %s - %s", e.name, ev.Start.Format("2006-01-02"), ev.End.Format("2006-01-02"), e.expectedStart, e.expectedEnd)
+		}
+		if ev.Summary != "Not available" || ev.Description != "" {
+			t.Errorf("%s: got summary %q and description %q", e.name, ev.Summary, ev.Description)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	if _, err := Parse(strings.NewReader("<html></html>")); !errors.Is(err, ErrNotCalendar) {
+		t.Errorf("expected ErrNotCalendar, got %v", err)
+	}
+
+	for name, input := range map[string]string{
+		"missing uid":    "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:20500101\nEND:VEVENT\nEND:VCALENDAR\n",
+		"malformed date": "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART;VALUE=DATE:2050-01-01\nEND:VEVENT\nEND:VCALENDAR\n",
+		"bad duration":   "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART;VALUE=DATE:20500101\nDURATION:PT5H\nEND:VEVENT\nEND:VCALENDAR\n",
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
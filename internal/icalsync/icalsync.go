@@ -0,0 +1,160 @@
+// Package icalsync imports the calendars other platforms, such as Airbnb or Booking.com, publish for the rooms,
+// so the nights booked there are blocked here
+package icalsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bangn/bookings/internal/ical"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/repository"
+)
+
+// maxFeedBytes is the largest calendar file a feed may serve
+const maxFeedBytes = 5 << 20
+
+// Syncer imports the events of the room calendar feeds as owner blocks
+type Syncer struct {
+	DB     repository.DatabaseRepo
+	Client *http.Client
+	// Now returns the current time, events over before today are not imported
+	Now func() time.Time
+}
+
+// New returns a Syncer writing the blocks to db
+func New(db repository.DatabaseRepo) *Syncer {
+	return &Syncer{
+		DB:     db,
+		Client: &http.Client{Timeout: 30 * time.Second},
+		Now:    time.Now,
+	}
+}
+
+// IsRemote reports whether the feed address is fetched over http(s) rather than read from a file
+func IsRemote(address string) bool {
+	return strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://")
+}
+
+// open returns the calendar file of a feed, an http(s) URL or the path of a local file
+func (s *Syncer) open(ctx context.Context, address string) (io.ReadCloser, error) {
+	if !IsRemote(address) {
+		return os.Open(address)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", address, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ical.ContentType)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching the feed: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fetch reads and parses the events of a feed
+func (s *Syncer) fetch(ctx context.Context, address string) ([]ical.Event, error) {
+	f, err := s.open(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	body, err := io.ReadAll(io.LimitReader(f, maxFeedBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxFeedBytes {
+		return nil, fmt.Errorf("the feed is larger than %d bytes", maxFeedBytes)
+	}
+
+	return ical.Parse(bytes.NewReader(body))
+}
+
+// Blocks turns the events of a feed into the blocks of its room. Cancelled events and events over before
+// today are left out, and so are repeated UIDs, a feed lists each booking once
+func Blocks(feed models.RoomCalendarFeed, events []ical.Event, today time.Time) []models.RoomRestriction {
+	var blocks []models.RoomRestriction
+	seen := make(map[string]bool)
+
+	for _, e := range events {
+		if e.Cancelled || !e.End.After(today) || !e.End.After(e.Start) || seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+
+		blocks = append(blocks, models.RoomRestriction{
+			RoomID:         feed.RoomID,
+			RestrictionID:  models.RestrictionOwnerBlock,
+			StartDate:      e.Start,
+			EndDate:        e.End,
+			Source:         models.SourceICal,
+			CalendarFeedID: feed.ID,
+			ExternalUID:    e.UID,
+		})
+	}
+
+	return blocks
+}
+
+// SyncFeed imports the events of one feed and records how the sync went on the feed,
+// when the feed can not be read its blocks are kept as they are. Ending ctx stops the fetch and the import
+func (s *Syncer) SyncFeed(ctx context.Context, feed models.RoomCalendarFeed) (models.ImportResult, error) {
+	now := s.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var result models.ImportResult
+	events, err := s.fetch(ctx, feed.URL)
+	if err == nil {
+		result, err = s.DB.ReplaceImportedBlocks(ctx, feed, Blocks(feed, events, today))
+	}
+
+	syncErr := ""
+	if err != nil {
+		syncErr = err.Error()
+	}
+	if statusErr := s.DB.SetRoomCalendarFeedSynced(ctx, feed.ID, now, syncErr); statusErr != nil && err == nil {
+		err = statusErr
+	}
+
+	return result, err
+}
+
+// SyncAll imports the events of every feed, a feed that fails does not stop the others
+func (s *Syncer) SyncAll(ctx context.Context) (models.ImportResult, error) {
+	var total models.ImportResult
+
+	feeds, err := s.DB.AllRoomCalendarFeeds(ctx)
+	if err != nil {
+		return total, err
+	}
+
+	var errs []error
+	for _, feed := range feeds {
+		result, err := s.SyncFeed(ctx, feed)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("feed %d %q of room %d: %w", feed.ID, feed.Name, feed.RoomID, err))
+			continue
+		}
+		total.Added += result.Added
+		total.Updated += result.Updated
+		total.Removed += result.Removed
+		total.Conflicts += result.Conflicts
+	}
+
+	return total, errors.Join(errs...)
+}
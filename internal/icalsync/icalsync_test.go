@@ -0,0 +1,159 @@
+package icalsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/ical"
+	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/repository/dbrepo"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func readFixture(t *testing.T, name string) []ical.Event {
+	t.Helper()
+
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	events, err := ical.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestBlocks(t *testing.T) {
+	feed := models.RoomCalendarFeed{ID: 7, RoomID: 2}
+	today := date(2026, time.October, 18)
+
+	var tests = []struct {
+		fixture  string
+		expected []models.RoomRestriction
+	}{
+		{"airbnb.ics", []models.RoomRestriction{
+			{StartDate: date(2050, time.June, 10), EndDate: date(2050, time.June, 13), ExternalUID: "1418fb94e984-f4d7c2a1@airbnb.com"},
+			{StartDate: date(2050, time.July, 1), EndDate: date(2050, time.July, 5), ExternalUID: "7f3a1d29b0c4-0a6b9e11@airbnb.com"},
+		}},
+		// the alarm of the first event is skipped, the repeated event is imported once and the cancelled one not at all
+		{"booking.ics", []models.RoomRestriction{
+			{StartDate: date(2050, time.August, 20), EndDate: date(2050, time.August, 23), ExternalUID: "8f2c9a7e@booking.com"},
+			{StartDate: date(2050, time.September, 1), EndDate: date(2050, time.September, 3), ExternalUID: "5b1e0d44@booking.com"},
+		}},
+	}
+
+	for _, tt := range tests {
+		blocks := Blocks(feed, readFixture(t, tt.fixture), today)
+		if len(blocks) != len(tt.expected) {
+			t.Errorf("%s: expected %d blocks, got %d", tt.fixture, len(tt.expected), len(blocks))
+			continue
+		}
+
+		for i, b := range blocks {
+			e := tt.expected[i]
+			if !b.StartDate.Equal(e.StartDate) || !b.EndDate.Equal(e.EndDate) || b.ExternalUID != e.ExternalUID {
+				t.Errorf("%s: block %d is %s %s - %s, expected %s %s - %s", tt.fixture, i,
+					b.ExternalUID, b.StartDate.Format("2006-01-02"), b.EndDate.Format("2006-01-02"),
+					e.ExternalUID, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"))
+			}
+			if b.RoomID != 2 || b.CalendarFeedID != 7 || b.Source != models.SourceICal || b.RestrictionID != models.RestrictionOwnerBlock {
+				t.Errorf("%s: block %d is not an imported owner block of the room: %+v", tt.fixture, i, b)
+			}
+		}
+	}
+}
+
+func TestBlocks_PastEventsAreSkipped(t *testing.T) {
+	// on the day the Airbnb booking of June 2050 ends only the July one is left
+	blocks := Blocks(models.RoomCalendarFeed{}, readFixture(t, "airbnb.ics"), date(2050, time.June, 13))
+	if len(blocks) != 1 || !blocks[0].StartDate.Equal(date(2050, time.July, 1)) {
+		t.Errorf("expected only the July block, got %+v", blocks)
+	}
+}
+
+func TestSyncer_SyncFeed(t *testing.T) {
+	airbnb, err := os.ReadFile("testdata/airbnb.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/airbnb.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", ical.ContentType)
+		w.Write(airbnb)
+	}))
+	defer srv.Close()
+
+	s := New(dbrepo.NewTestingPostgresRepo(&config.AppConfig{}))
+	s.Now = func() time.Time { return time.Date(2026, time.October, 18, 9, 30, 0, 0, time.UTC) }
+
+	var tests = []struct {
+		name          string
+		url           string
+		expectedAdded int
+		expectError   bool
+	}{
+		{"local file", "testdata/airbnb.ics", 2, false},
+		{"booking.com file", "testdata/booking.ics", 2, false},
+		{"over http", srv.URL + "/airbnb.ics", 2, false},
+		{"missing file", "testdata/missing.ics", 0, true},
+		{"not a calendar", "testdata/invalid.ics", 0, true},
+		{"http error", srv.URL + "/missing.ics", 0, true},
+	}
+
+	for _, tt := range tests {
+		result, err := s.SyncFeed(context.Background(), models.RoomCalendarFeed{ID: 1, RoomID: 1, URL: tt.url})
+		if tt.expectError != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if result.Added != tt.expectedAdded {
+			t.Errorf("%s: expected %d blocks added, got %d", tt.name, tt.expectedAdded, result.Added)
+		}
+	}
+}
+
+func TestSyncer_SyncAll(t *testing.T) {
+	s := New(dbrepo.NewTestingPostgresRepo(&config.AppConfig{}))
+	// the feeds of the testing repository are relative to the handlers package
+	t.Chdir("../handlers")
+
+	total, err := s.SyncAll(context.Background())
+	if err == nil {
+		t.Error("expected the error of the broken feed")
+	}
+	if total.Added != 2 {
+		t.Errorf("expected the 2 blocks of the working feed, got %d", total.Added)
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	var tests = []struct {
+		address  string
+		expected bool
+	}{
+		{"https://www.airbnb.com/calendar/ical/1.ics?s=abc", true},
+		{"http://example.com/room.ics", true},
+		{"/var/lib/bookings/room.ics", false},
+		{"ftp://example.com/room.ics", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsRemote(tt.address); got != tt.expected {
+			t.Errorf("IsRemote(%q) = %v, expected %v", tt.address, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package logging sets up the structured logger of the application
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// New returns a logger writing to w, as JSON in production so the log collector can parse it,
+// as text with the debug messages otherwise. Records logged with the context of a request carry its request_id
+func New(w io.Writer, production bool) *slog.Logger {
+	var h slog.Handler
+	if production {
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo})
+	} else {
+		h = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	return slog.New(requestIDHandler{h})
+}
+
+// RequestID returns the ID the RequestID middleware of chi gave to the request of ctx, "" outside of a request
+func RequestID(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+// requestIDHandler adds the request ID found in the context to the records
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
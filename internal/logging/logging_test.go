@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+func TestNew_Production(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, true)
+
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "host/abc-000001")
+	logger.With("worker", "mailer").InfoContext(ctx, "mail sent", "to", "guest@example.com")
+	logger.Debug("not in production")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one record, debug messages are left out in production, got %q", buf.String())
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("production logs are JSON: %v", err)
+	}
+	for key, expected := range map[string]string{
+		"level":      "INFO",
+		"msg":        "mail sent",
+		"to":         "guest@example.com",
+		"worker":     "mailer",
+		"request_id": "host/abc-000001",
+	} {
+		if record[key] != expected {
+			t.Errorf("%s: got %v, expected %q", key, record[key], expected)
+		}
+	}
+}
+
+func TestNew_Development(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, false)
+
+	logger.Debug("start date", "date", "2050-01-01")
+
+	out := buf.String()
+	if !strings.Contains(out, `level=DEBUG msg="start date" date=2050-01-01`) {
+		t.Errorf("expected a debug text record, got %q", out)
+	}
+	if strings.Contains(out, "request_id") {
+		t.Errorf("there is no request ID outside of a request, got %q", out)
+	}
+}
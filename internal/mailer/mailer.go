@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/bangn/bookings/internal/models"
+)
+
+// Transport delivers a rendered message
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// Mailer renders mail templates and hands the result to a Transport
+type Mailer struct {
+	transport    Transport
+	templatesDir string
+	from         string
+	logger       *slog.Logger
+}
+
+// New creates a Mailer, from is used for every MailData without a sender
+func New(t Transport, templatesDir, from string, logger *slog.Logger) *Mailer {
+	return &Mailer{
+		transport:    t,
+		templatesDir: templatesDir,
+		from:         from,
+		logger:       logger,
+	}
+}
+
+// Listen sends every MailData received on ch until ch is closed,
+// it is meant to run in its own goroutine so handlers never wait for the mail server
+func (m *Mailer) Listen(ch <-chan models.MailData) {
+	for md := range ch {
+		err := m.Send(md)
+		if err != nil {
+			m.logger.Error("failed to send mail", "template", md.Template, "to", md.To, "error", err)
+		}
+	}
+}
+
+// Send renders and delivers one mail right away
+func (m *Mailer) Send(md models.MailData) error {
+	msg, err := m.Render(md)
+	if err != nil {
+		return err
+	}
+	return m.transport.Send(msg)
+}
+
+// Render builds a message from templates/email/<Template>.html.tmpl and <Template>.txt.tmpl,
+// at least one of them must exist
+func (m *Mailer) Render(md models.MailData) (*Message, error) {
+	from := md.From
+	if from == "" {
+		from = m.from
+	}
+
+	msg := &Message{
+		From:    from,
+		To:      []string{md.To},
+		Subject: md.Subject,
+	}
+
+	htmlPath := filepath.Join(m.templatesDir, md.Template+".html.tmpl")
+	if fileExists(htmlPath) {
+		t, err := htmltemplate.ParseFiles(htmlPath)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = t.Execute(&buf, md.Data); err != nil {
+			return nil, err
+		}
+		msg.HTML = buf.String()
+	}
+
+	textPath := filepath.Join(m.templatesDir, md.Template+".txt.tmpl")
+	if fileExists(textPath) {
+		t, err := template.ParseFiles(textPath)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = t.Execute(&buf, md.Data); err != nil {
+			return nil, err
+		}
+		msg.Text = buf.String()
+	}
+
+	if msg.HTML == "" && msg.Text == "" {
+		return nil, fmt.Errorf("no mail template named %q in %s", md.Template, m.templatesDir)
+	}
+
+	return msg, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bangn/bookings/internal/models"
+)
+
+var pathToTemplates = "./../../templates/email"
+
+// fakeTransport remembers the messages instead of sending them
+type fakeTransport struct {
+	sent []*Message
+}
+
+func (t *fakeTransport) Send(msg *Message) error {
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+func testMailData() models.MailData {
+	return models.MailData{
+		To:       "john@smith.com",
+		Subject:  "Reservation Confirmation",
+		Template: "reservation-confirmation",
+		Data: map[string]interface{}{
+			"Reservation": models.Reservation{
+				FirstName: "John",
+				StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+				Room:      models.Room{RoomName: "General's Quarters"},
+			},
+			"Total": "$240.00",
+			"Link":  "http://localhost:8080/reservations/abc",
+		},
+	}
+}
+
+func TestMailer_Render(t *testing.T) {
+	m := New(&fakeTransport{}, pathToTemplates, "owner@example.com", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	msg, err := m.Render(testMailData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.From != "owner@example.com" {
+		t.Errorf("expected default sender, got %q", msg.From)
+	}
+	if !strings.Contains(msg.Text, "2050-01-01") || !strings.Contains(msg.Text, "/reservations/abc") {
+		t.Errorf("text version misses reservation details:\n%s", msg.Text)
+	}
+	if !strings.Contains(msg.HTML, "General&#39;s Quarters") {
+		t.Errorf("html version misses escaped room name:\n%s", msg.HTML)
+	}
+
+	md := testMailData()
+	md.Template = "does-not-exist"
+	if _, err = m.Render(md); err == nil {
+		t.Error("expected error for unknown template")
+	}
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	msg := &Message{
+		From:    "Owner <owner@example.com>",
+		To:      []string{"john@smith.com"},
+		Subject: "Réservation",
+		Text:    "hello",
+		HTML:    "<p>hello</p>",
+	}
+
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+
+	for _, want := range []string{
+		"To: john@smith.com\r\n",
+		"Subject: =?utf-8?q?R=C3=A9servation?=\r\n",
+		"Content-Type: multipart/alternative;",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Type: text/html; charset=utf-8",
+		"@example.com>\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("encoded message misses %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFileTransport_Send(t *testing.T) {
+	dir := t.TempDir()
+	ft := &FileTransport{Dir: filepath.Join(dir, "mail")}
+
+	err := ft.Send(&Message{From: "owner@example.com", To: []string{"john@smith.com"}, Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %d", len(files))
+	}
+}
+
+func TestMailer_Listen(t *testing.T) {
+	ft := &fakeTransport{}
+	m := New(ft, pathToTemplates, "owner@example.com", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	ch := make(chan models.MailData, 2)
+	ch <- testMailData()
+	ch <- testMailData()
+	close(ch)
+
+	// Listen returns once the channel is closed and drained
+	m.Listen(ch)
+
+	if len(ft.sent) != 2 {
+		t.Errorf("expected 2 sent mails, got %d", len(ft.sent))
+	}
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// SMTPTransport delivers messages through an SMTP server,
+// authentication is only used when Username is set
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Send delivers msg to its recipients
+func (t *SMTPTransport) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", msg.From, err)
+	}
+
+	var auth smtp.Auth
+	if t.Username != "" {
+		auth = smtp.PlainAuth("", t.Username, t.Password, t.Host)
+	}
+
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	return smtp.SendMail(addr, auth, from.Address, msg.To, body)
+}
+
+// FileTransport writes every message as an .eml file into Dir instead of sending it,
+// which lets us look at the mails locally without a mail server
+type FileTransport struct {
+	Dir string
+}
+
+// Send writes msg to a new file in Dir
+func (t *FileTransport) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(t.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	suffix, err := randomHex(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), suffix)
+
+	return os.WriteFile(filepath.Join(t.Dir, name), body, 0o644)
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// the metrics of the web server
+var (
+	HTTPRequests = NewCounterVec("bookings_http_requests_total",
+		"HTTP requests answered, by route pattern", "method", "route", "status")
+	HTTPDuration = NewHistogramVec("bookings_http_request_duration_seconds",
+		"Time taken to answer HTTP requests, by route pattern", DefaultBuckets, "method", "route")
+)
+
+// the metrics of the bookings, channel is web or api
+var (
+	ReservationsCreated = NewCounterVec("bookings_reservations_created_total",
+		"Reservations booked", "channel")
+	ReservationsRejected = NewCounterVec("bookings_reservations_rejected_total",
+		"Bookings refused, reason is unavailable, over_capacity or promo_code", "channel", "reason")
+	PaymentsConfirmed = NewCounterVec("bookings_payments_confirmed_total",
+		"Reservations confirmed by their payment")
+	ReservationsExpired = NewCounterVec("bookings_reservations_expired_total",
+		"Reservations cancelled because they were not paid in time")
+)
+
+func init() {
+	Default.Register(HTTPRequests, HTTPDuration, ReservationsCreated, ReservationsRejected, PaymentsConfirmed, ReservationsExpired)
+}
+
+// DBStats reports the statistics of a database pool
+func DBStats(db *sql.DB) Collector {
+	return CollectorFunc(func(w *Writer) {
+		s := db.Stats()
+
+		gauges := []struct {
+			name, help string
+			value      float64
+		}{
+			{"bookings_db_max_open_connections", "Maximum number of open connections to the database", float64(s.MaxOpenConnections)},
+			{"bookings_db_open_connections", "Connections to the database, in use and idle", float64(s.OpenConnections)},
+			{"bookings_db_in_use_connections", "Connections to the database in use", float64(s.InUse)},
+			{"bookings_db_idle_connections", "Idle connections to the database", float64(s.Idle)},
+		}
+		for _, g := range gauges {
+			w.Family(g.name, g.help, "gauge")
+			w.Sample(g.name, g.value)
+		}
+
+		counters := []struct {
+			name, help string
+			value      float64
+		}{
+			{"bookings_db_wait_count_total", "Times a connection had to be waited for", float64(s.WaitCount)},
+			{"bookings_db_wait_duration_seconds_total", "Time spent waiting for a connection", s.WaitDuration.Seconds()},
+			{"bookings_db_max_idle_closed_total", "Connections closed because of the idle limit", float64(s.MaxIdleClosed)},
+			{"bookings_db_max_idle_time_closed_total", "Connections closed because they were idle too long", float64(s.MaxIdleTimeClosed)},
+			{"bookings_db_max_lifetime_closed_total", "Connections closed because of their maximum lifetime", float64(s.MaxLifetimeClosed)},
+		}
+		for _, c := range counters {
+			w.Family(c.name, c.help, "counter")
+			w.Sample(c.name, c.value)
+		}
+	})
+}
@@ -0,0 +1,288 @@
+// Package metrics keeps the counters of the application and serves them in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector writes one or more metric families
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// Registry holds the collectors served together, it is the http.Handler of /metrics
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// Default is the registry of the application, the metrics of this package are registered with it
+var Default = &Registry{}
+
+// Register adds collectors to the registry
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// WriteTo writes every metric of the registry to w
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	mw := &Writer{w: bufio.NewWriter(w)}
+	for _, c := range collectors {
+		c.Collect(mw)
+	}
+	return mw.n, mw.flush()
+}
+
+// ServeHTTP serves the metrics of the registry
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// Writer writes metric families in the Prometheus text format
+type Writer struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (mw *Writer) write(s string) {
+	if mw.err != nil {
+		return
+	}
+	n, err := mw.w.WriteString(s)
+	mw.n += int64(n)
+	mw.err = err
+}
+
+func (mw *Writer) flush() error {
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// Family starts a metric family, typ is counter, gauge or histogram
+func (mw *Writer) Family(name, help, typ string) {
+	mw.write("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	mw.write("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes one value of the current family, labels alternate names and values
+func (mw *Writer) Sample(name string, value float64, labels ...string) {
+	mw.write(name + formatLabels(labels) + " " + formatValue(value) + "\n")
+}
+
+// labelEscaper escapes label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats name, value pairs as {name="value",...}
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labelPairs zips label names and values into the name, value pairs Sample takes
+func labelPairs(names, values []string, extra ...string) []string {
+	pairs := make([]string, 0, 2*len(names)+len(extra))
+	for i, name := range names {
+		pairs = append(pairs, name, values[i])
+	}
+	return append(pairs, extra...)
+}
+
+// seriesKey joins label values into a map key, the keys sort as the values do
+func seriesKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+// CounterVec is a counter with one series per combination of label values
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	keys   []string
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	count  float64
+}
+
+// NewCounterVec returns a counter, it has to be registered to be served
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, series: make(map[string]*counterSeries)}
+}
+
+// Inc adds one to the series of the label values, given in the order of the label names
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the series of the label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+
+	key := seriesKey(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{values: slices.Clone(values)}
+		c.series[key] = s
+		c.keys = append(c.keys, key)
+		slices.Sort(c.keys)
+	}
+	s.count += v
+}
+
+// Value returns the count of the series of the label values
+func (c *CounterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s, ok := c.series[seriesKey(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+// Collect writes the counter
+func (c *CounterVec) Collect(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w.Family(c.name, c.help, "counter")
+	for _, key := range c.keys {
+		s := c.series[key]
+		w.Sample(c.name, s.count, labelPairs(c.labels, s.values)...)
+	}
+}
+
+// HistogramVec counts observations, such as latencies, in buckets, with one series per combination of label values
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	keys   []string
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	values []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec returns a histogram with the given bucket upper bounds, in increasing order
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// Observe records v in the series of the label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", h.name, len(h.labels), len(values)))
+	}
+
+	key := seriesKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{values: slices.Clone(values), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.keys = append(h.keys, key)
+		slices.Sort(h.keys)
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Collect writes the histogram, the buckets are cumulative as Prometheus expects
+func (h *HistogramVec) Collect(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	w.Family(h.name, h.help, "histogram")
+	for _, key := range h.keys {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			w.Sample(h.name+"_bucket", float64(s.counts[i]), labelPairs(h.labels, s.values, "le", formatValue(upper))...)
+		}
+		w.Sample(h.name+"_bucket", float64(s.count), labelPairs(h.labels, s.values, "le", "+Inf")...)
+		w.Sample(h.name+"_sum", s.sum, labelPairs(h.labels, s.values)...)
+		w.Sample(h.name+"_count", float64(s.count), labelPairs(h.labels, s.values)...)
+	}
+}
+
+// CollectorFunc turns a function into a Collector, for values read when they are served
+type CollectorFunc func(w *Writer)
+
+// Collect calls f
+func (f CollectorFunc) Collect(w *Writer) {
+	f(w)
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Requests\nby route", "route", "status")
+	c.Inc("/rooms/{slug}", "200")
+	c.Add(2, "/rooms/{slug}", "200")
+	c.Inc("/", `5"00`)
+
+	if v := c.Value("/rooms/{slug}", "200"); v != 3 {
+		t.Errorf("expected 3, got %v", v)
+	}
+	if v := c.Value("/about", "200"); v != 0 {
+		t.Errorf("a series never counted is 0, got %v", v)
+	}
+
+	r := &Registry{}
+	r.Register(c)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the series are sorted by label values, quotes and new lines are escaped
+	expected := `# HELP test_requests_total Requests\nby route
+# TYPE test_requests_total counter
+test_requests_total{route="/",status="5\"00"} 1
+test_requests_total{route="/rooms/{slug}",status="200"} 3
+`
+	if b.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", b.String(), expected)
+	}
+}
+
+func TestCounterVec_WrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	NewCounterVec("test_total", "Test", "channel").Inc()
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Durations", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(3, "/")
+
+	r := &Registry{}
+	r.Register(h)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the buckets are cumulative, the +Inf one is the count
+	expected := `# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/",le="0.1"} 1
+test_duration_seconds_bucket{route="/",le="1"} 2
+test_duration_seconds_bucket{route="/",le="+Inf"} 3
+test_duration_seconds_sum{route="/"} 3.55
+test_duration_seconds_count{route="/"} 3
+`
+	if b.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", b.String(), expected)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	db, err := sql.Open("pgx", "host=localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(10)
+
+	r := &Registry{}
+	r.Register(DBStats(db), CollectorFunc(func(w *Writer) {
+		w.Family("test_up", "Always up", "gauge")
+		w.Sample("test_up", 1)
+	}))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Header().Get("Content-Type") != ContentType {
+		t.Errorf("wrong content type %q", rr.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		"# TYPE bookings_db_open_connections gauge",
+		"bookings_db_max_open_connections 10",
+		"bookings_db_wait_count_total 0",
+		"test_up 1",
+	} {
+		if !strings.Contains(rr.Body.String(), line+"\n") {
+			t.Errorf("%q is missing from\n%s", line, rr.Body.String())
+		}
+	}
+}
@@ -1,62 +1,416 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"strings"
+	"time"
+)
 
 // Reservation is the type for reservations in the system
 type Reservation struct {
-	ID		int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
+	ID        int       `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Phone     string    `json:"phone"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	RoomID    int       `json:"room_id"`
+	Room      Room      `json:"room,omitzero"`
+	// Rooms are all the rooms of a party split across several, Room is the first of them
+	Rooms     []Room `json:"rooms,omitempty"`
+	Adults    int    `json:"adults"`
+	Children  int    `json:"children"`
+	// Total is the price quoted for the stay when it was booked, in cents of Currency
+	Total    int    `json:"total"`
+	Currency string `json:"currency"`
+	// Discount is the amount taken off the price by the promo code, Total is already discounted
+	Discount    int `json:"discount"`
+	PromoCodeID int `json:"promo_code_id,omitempty"`
+	// Status is one of the Reservation* statuses, PaymentIntentID the payment taken for the stay
+	Status          string `json:"status"`
+	PaymentIntentID string `json:"payment_intent_id,omitempty"`
+	// Token is the unguessable key of the guest's /reservations/{token} link
+	Token       string    `json:"token,omitempty"`
+	CancelledAt time.Time `json:"cancelled_at,omitzero"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// DraftID is the booking draft the guest booked from, its hold on the rooms does not stand in its own way
+	DraftID string `json:"-"`
+}
+
+// statuses of reservations
+const (
+	// ReservationNew reservations have nothing to pay and wait for staff to confirm them
+	ReservationNew = "new"
+	// ReservationPendingPayment reservations hold their room until they are paid or expire
+	ReservationPendingPayment = "pending_payment"
+	ReservationConfirmed      = "confirmed"
+	ReservationCheckedIn      = "checked_in"
+	ReservationCheckedOut     = "checked_out"
+	ReservationNoShow         = "no_show"
+	ReservationCancelled      = "cancelled"
+	ReservationRefunded       = "refunded"
+)
+
+// ReservationStatuses lists every status of a reservation, in the order of its lifecycle
+var ReservationStatuses = []string{
+	ReservationNew, ReservationPendingPayment, ReservationConfirmed, ReservationCheckedIn,
+	ReservationCheckedOut, ReservationNoShow, ReservationCancelled, ReservationRefunded,
+}
+
+// ReservationFilter narrows down the reservations listed to staff, zero fields do not filter. From and To keep
+// the stays with a night between them, Guest is a part of the name or of the email of the guest
+type ReservationFilter struct {
+	Status string
+	From   time.Time
+	To     time.Time
+	RoomID int
+	Guest  string
+}
+
+// reservationTransitions lists the statuses a reservation may move to from each status,
+// statuses missing from the map are final
+var reservationTransitions = map[string][]string{
+	ReservationNew:            {ReservationConfirmed, ReservationCancelled},
+	ReservationPendingPayment: {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed:      {ReservationCheckedIn, ReservationNoShow, ReservationCancelled, ReservationRefunded},
+	ReservationCheckedIn:      {ReservationCheckedOut},
+	ReservationCancelled:      {ReservationRefunded},
+}
+
+// CanTransition reports whether a reservation may move from one status to another
+func CanTransition(from, to string) bool {
+	return slices.Contains(reservationTransitions[from], to)
+}
+
+// NextStatuses returns the statuses the reservation may move to
+func (r Reservation) NextStatuses() []string {
+	return reservationTransitions[r.Status]
+}
+
+// IsPendingPayment reports whether the reservation still waits for its payment
+func (r Reservation) IsPendingPayment() bool {
+	return r.Status == ReservationPendingPayment
+}
+
+// CanBeChanged reports whether the guest may still change the dates of the reservation or cancel it
+func (r Reservation) CanBeChanged() bool {
+	return CanTransition(r.Status, ReservationCancelled)
+}
+
+// Actor is who changed the status of a reservation, UserID is set when it is a member of staff
+type Actor struct {
+	Name   string
+	UserID int
+}
+
+// actors that are not staff
+var (
+	ActorGuest    = Actor{Name: "guest"}
+	ActorSystem   = Actor{Name: "system"}
+	ActorPayments = Actor{Name: "payment provider"}
+)
+
+// ReservationEvent is the type for the history of the statuses of a reservation, FromStatus is empty for its creation
+type ReservationEvent struct {
+	ID            int    `json:"id"`
+	ReservationID int    `json:"reservation_id"`
+	FromStatus    string `json:"from_status"`
+	ToStatus      string `json:"to_status"`
+	Actor         string `json:"actor"`
+	UserID        int    `json:"user_id,omitempty"`
+	// User is the member of staff who made the change, when UserID is set
+	User      User      `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Guests returns the size of the party
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// RoomIDs returns the ids of the rooms of the reservation
+func (r Reservation) RoomIDs() []int {
+	if len(r.Rooms) == 0 {
+		return []int{r.RoomID}
+	}
+
+	ids := make([]int, len(r.Rooms))
+	for i, room := range r.Rooms {
+		ids[i] = room.ID
+	}
+	return ids
+}
+
+// RoomNames returns the names of the rooms of the reservation, for display
+func (r Reservation) RoomNames() string {
+	if len(r.Rooms) == 0 {
+		return r.Room.RoomName
+	}
+
+	names := make([]string, len(r.Rooms))
+	for i, room := range r.Rooms {
+		names[i] = room.RoomName
+	}
+	return strings.Join(names, ", ")
+}
+
+// Subtotal returns the price of the stay before the discount
+func (r Reservation) Subtotal() int {
+	return r.Total + r.Discount
+}
+
+// IsPaid reports whether the payment of the reservation was taken, its total can not change any more
+func (r Reservation) IsPaid() bool {
+	return r.PaymentIntentID != "" && !r.IsPendingPayment()
+}
+
+// IsCancelled reports whether the reservation has been cancelled
+func (r Reservation) IsCancelled() bool {
+	return !r.CancelledAt.IsZero()
+}
+
+// BookingDraft is a booking on its way through the funnel, from the search to the make reservation form.
+// Its ID is in the URLs of the funnel, so every tab has its own draft; the rooms chosen are held for
+// the dates until ExpiresAt, see RestrictionHold, and the draft remembers the reservation it was booked as
+type BookingDraft struct {
+	ID        string
 	StartDate time.Time
 	EndDate   time.Time
-	RoomID    int
-	Room      Room
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	Adults    int
+	Children  int
+	// RoomIDs are the rooms chosen, empty until the guest picks one
+	RoomIDs       []int
+	ReservationID int
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
+// IsBooked reports whether the draft was booked, ReservationID is the reservation then
+func (d BookingDraft) IsBooked() bool {
+	return d.ReservationID != 0
+}
+
+// IsExpired reports whether the draft stopped holding its rooms at now
+func (d BookingDraft) IsExpired(now time.Time) bool {
+	return !now.Before(d.ExpiresAt)
+}
+
+// Reservation returns the reservation the draft is a draft of, with the ids of its rooms
+func (d BookingDraft) Reservation() Reservation {
+	res := Reservation{
+		StartDate: d.StartDate,
+		EndDate:   d.EndDate,
+		Adults:    d.Adults,
+		Children:  d.Children,
+		DraftID:   d.ID,
+	}
+	if len(d.RoomIDs) > 0 {
+		res.RoomID = d.RoomIDs[0]
+	}
+	if len(d.RoomIDs) > 1 {
+		for _, id := range d.RoomIDs {
+			res.Rooms = append(res.Rooms, Room{ID: id})
+		}
+	}
+	return res
+}
+
+// access levels of users, also used as scopes of their API keys
+const (
+	// AccessLevelUser is the default users.access_level
+	AccessLevelUser = 1
+	// AccessLevelAdmin is the minimum users.access_level allowed into the admin area
+	AccessLevelAdmin = 3
+)
+
 // User is the type for users of the system
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	Created_at  time.Time
-	Updated_at  time.Time
+	ID          int       `json:"id"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Email       string    `json:"email"`
+	Password    string    `json:"-"`
+	AccessLevel int       `json:"access_level"`
+	Created_at  time.Time `json:"created_at"`
+	Updated_at  time.Time `json:"updated_at"`
+}
+
+// APIKey is the type for the API keys of users, only a hash of the key itself is stored
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	User   User   `json:"user,omitzero"`
+	Name   string `json:"name"`
+	// Prefix is the start of the key, shown so staff can tell keys apart
+	Prefix string `json:"prefix"`
+	// Scope is the access level granted to requests made with the key, never above the one of its user
+	Scope      int       `json:"scope"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// IsRevoked reports whether the key has been revoked
+func (k APIKey) IsRevoked() bool {
+	return !k.RevokedAt.IsZero()
 }
 
 // Room is the type for rooms in the system
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID       int    `json:"id"`
+	RoomName string `json:"room_name"`
+	// Slug names the room in its public URL, /rooms/{slug}
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	// Capacity is the number of guests the room sleeps
+	Capacity int `json:"capacity"`
+	// NightlyPrice is the price of one night, in cents
+	NightlyPrice int         `json:"nightly_price"`
+	Images       []RoomImage `json:"images,omitempty"`
+	// CalendarToken is the key of the room's /rooms/{id}/calendar.ics feed, it is never shown outside the admin
+	CalendarToken string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at,omitzero"`
+	UpdatedAt     time.Time `json:"updated_at,omitzero"`
+}
+
+// RoomImage is the type for the pictures of a room, shown in the order of Position
+type RoomImage struct {
+	ID       int    `json:"-"`
+	RoomID   int    `json:"-"`
+	Path     string `json:"path"`
+	Position int    `json:"-"`
+}
+
+// kinds of promo codes
+const (
+	// PromoPercent codes take Value percent off the price
+	PromoPercent = "percent"
+	// PromoFixed codes take Value cents off the price
+	PromoFixed = "fixed"
+)
+
+// PromoCode is the type for the discount codes guests can enter when booking
+type PromoCode struct {
+	ID          int    `json:"id"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Value       int    `json:"value"`
+	// the code can be used from ValidFrom through ValidUntil, a missing date leaves that side open
+	ValidFrom  time.Time `json:"valid_from,omitzero"`
+	ValidUntil time.Time `json:"valid_until,omitzero"`
+	// MaxRedemptions is the number of bookings the code can be used for, 0 for no limit
+	MaxRedemptions int `json:"max_redemptions"`
+	Redemptions    int `json:"redemptions"`
+	// RoomIDs are the rooms the code can be used for, empty for every room
+	RoomIDs   []int     `json:"room_ids,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
 }
 
+// ids of the rows of the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	// RestrictionHold keeps a room for a guest while they fill in the make reservation form, until ExpiresAt
+	RestrictionHold = 3
+)
+
 // Restriction is the type for restrictions in the system
 type Restriction struct {
-	ID        int
-	RestrictionName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID              int       `json:"id"`
+	RestrictionName string    `json:"restriction_name"`
+	CreatedAt       time.Time `json:"created_at,omitzero"`
+	UpdatedAt       time.Time `json:"updated_at,omitzero"`
 }
 
 // RoomRestriction is the type for room restrictions in the system
 type RoomRestriction struct {
-	ID            int
-	RoomID        int
-	RestrictionID int
-	ReservationID int
-	StartDate time.Time
-	EndDate   time.Time
-	Room	Room
-	Reservations Reservation
-	Restrictions Restriction
+	ID            int         `json:"id"`
+	RoomID        int         `json:"room_id"`
+	RestrictionID int         `json:"restriction_id"`
+	ReservationID int         `json:"reservation_id,omitempty"`
+	StartDate     time.Time   `json:"start_date"`
+	EndDate       time.Time   `json:"end_date"`
+	Room          Room        `json:"room,omitzero"`
+	Reservations  Reservation `json:"reservation,omitzero"`
+	Restrictions  Restriction `json:"restriction,omitzero"`
+	// Source is where a restriction comes from, empty when it was made here
+	Source string `json:"source,omitempty"`
+	// CalendarFeedID and ExternalUID identify the event an imported block was made from
+	CalendarFeedID int    `json:"calendar_feed_id,omitempty"`
+	ExternalUID    string `json:"external_uid,omitempty"`
+	// ExpiresAt is when a hold stops keeping the room, the other restrictions do not expire
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// IsImported reports whether the restriction mirrors a booking made on another platform
+func (r RoomRestriction) IsImported() bool {
+	return r.CalendarFeedID != 0
+}
+
+// IsHold reports whether the restriction keeps the room for a guest who is booking it, see RestrictionHold
+func (r RoomRestriction) IsHold() bool {
+	return r.RestrictionID == RestrictionHold
+}
+
+// SourceICal is the Source of the blocks imported from the calendar feeds of other platforms
+const SourceICal = "ical"
+
+// RoomCalendarFeed is an iCalendar feed another platform publishes for a room, e.g. its Airbnb calendar.
+// Its events are imported as owner blocks of the room
+type RoomCalendarFeed struct {
+	ID     int    `json:"id"`
+	RoomID int    `json:"room_id"`
+	Name   string `json:"name"`
+	// URL is an http(s) address, or the path of a local file
+	URL          string    `json:"url"`
+	LastSyncedAt time.Time `json:"last_synced_at,omitzero"`
+	// LastError is why the last sync failed, empty when it succeeded
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// ImportResult counts what the sync of a calendar feed changed in the blocks of its room.
+// Conflicts are the imported blocks overlapping a reservation made here, the room is double booked
+type ImportResult struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Removed   int `json:"removed"`
+	Conflicts int `json:"conflicts"`
+}
+
+// RoomRate is the type for the rates of the pricing engine. A rate without RoomID applies to every room,
+// a rate without dates applies all year, otherwise it covers the nights from StartDate up to, not including, EndDate
+type RoomRate struct {
+	ID        int       `json:"id"`
+	RoomID    int       `json:"room_id,omitempty"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date,omitzero"`
+	EndDate   time.Time `json:"end_date,omitzero"`
+	// prices of one night in cents, WeekendPrice is used for Friday and Saturday nights when set
+	WeekdayPrice int `json:"weekday_price"`
+	WeekendPrice int `json:"weekend_price,omitempty"`
+	// MinNights is the shortest stay allowed when one of its nights falls under the rate
+	MinNights int       `json:"min_nights"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
 
-	CreatedAt time.Time
-	UpdatedAt time.Time
+// MailData holds a mail waiting to be rendered and sent by the mailer
+type MailData struct {
+	To      string
+	From    string
+	Subject string
+	// Template is the base name of the templates in templates/email, without the .html.tmpl / .txt.tmpl suffix
+	Template string
+	Data     interface{}
 }
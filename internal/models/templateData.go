@@ -14,4 +14,7 @@ type TemplateData struct {
 	Warning   string
 	Error     string
 	Form      *forms.Form
+	// IsAuthenticated is 1 when a user is logged in, User then holds who it is
+	IsAuthenticated int
+	User            User
 }
\ No newline at end of file
@@ -0,0 +1,91 @@
+// Package occupancy finds the rooms, or combinations of rooms, that can host a party
+package occupancy
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bangn/bookings/internal/models"
+)
+
+// MaxRooms is the largest number of rooms a party is split across
+const MaxRooms = 3
+
+// Option is one or more rooms able to host a party together
+type Option struct {
+	Rooms    []models.Room
+	Capacity int
+}
+
+// IDs returns the ids of the rooms of the option joined by commas, as /bookings/{draft}/choose-rooms takes them
+func (o Option) IDs() string {
+	ids := make([]string, len(o.Rooms))
+	for i, room := range o.Rooms {
+		ids[i] = strconv.Itoa(room.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// Names returns the names of the rooms of the option
+func (o Option) Names() string {
+	names := make([]string, len(o.Rooms))
+	for i, room := range o.Rooms {
+		names[i] = room.RoomName
+	}
+	return strings.Join(names, " + ")
+}
+
+// Options returns the ways the available rooms can host guests. Every room big enough is an option;
+// only when there is none the party is split across up to MaxRooms rooms, offering the combinations
+// no room can be left out of, those with the fewest rooms and the fewest spare beds first
+func Options(available []models.Room, guests int) []Option {
+	var options []Option
+
+	for _, room := range available {
+		if room.Capacity >= guests {
+			options = append(options, Option{Rooms: []models.Room{room}, Capacity: room.Capacity})
+		}
+	}
+	if len(options) > 0 {
+		return options
+	}
+
+	combine(available, guests, nil, 0, &options)
+
+	slices.SortStableFunc(options, func(a, b Option) int {
+		if len(a.Rooms) != len(b.Rooms) {
+			return len(a.Rooms) - len(b.Rooms)
+		}
+		return a.Capacity - b.Capacity
+	})
+
+	return options
+}
+
+// combine adds to options every combination of rooms, starting with picked and taking rooms from
+// available[from:], that hosts guests but would not without any one of its rooms
+func combine(available []models.Room, guests int, picked []models.Room, from int, options *[]Option) {
+	capacity := 0
+	smallest := 0
+	for i, room := range picked {
+		capacity += room.Capacity
+		if i == 0 || room.Capacity < smallest {
+			smallest = room.Capacity
+		}
+	}
+
+	if capacity >= guests {
+		if len(picked) > 1 && capacity-smallest < guests {
+			*options = append(*options, Option{Rooms: slices.Clone(picked), Capacity: capacity})
+		}
+		return
+	}
+	if len(picked) == MaxRooms {
+		return
+	}
+
+	for i := from; i < len(available); i++ {
+		combine(available, guests, append(picked, available[i]), i+1, options)
+	}
+}
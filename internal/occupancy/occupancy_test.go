@@ -0,0 +1,44 @@
+package occupancy
+
+import (
+	"testing"
+
+	"github.com/bangn/bookings/internal/models"
+)
+
+var rooms = []models.Room{
+	{ID: 1, RoomName: "General's Quarters", Capacity: 2},
+	{ID: 2, RoomName: "Major's Suite", Capacity: 4},
+	{ID: 3, RoomName: "Colonel's Cabin", Capacity: 3},
+}
+
+var optionTests = []struct {
+	name     string
+	guests   int
+	expected []string
+}{
+	{"every room fits", 2, []string{"1", "2", "3"}},
+	{"only the big rooms fit", 4, []string{"2"}},
+	{"split across two rooms", 5, []string{"1,3", "1,2", "2,3"}},
+	{"split across three rooms", 8, []string{"1,2,3"}},
+	{"too many guests", 10, nil},
+}
+
+func TestOptions(t *testing.T) {
+	for _, e := range optionTests {
+		options := Options(rooms, e.guests)
+
+		if len(options) != len(e.expected) {
+			t.Errorf("%s: got %d options, wanted %d", e.name, len(options), len(e.expected))
+			continue
+		}
+		for i, option := range options {
+			if option.IDs() != e.expected[i] {
+				t.Errorf("%s: option %d is rooms %s, wanted %s", e.name, i, option.IDs(), e.expected[i])
+			}
+			if option.Capacity < e.guests {
+				t.Errorf("%s: option %s only sleeps %d", e.name, option.IDs(), option.Capacity)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestFakeProvider_Lifecycle(t *testing.T) {
+	p := NewFakeProvider("secret")
+
+	intent, err := p.CreateIntent(24000, "USD", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intent.Status != StatusRequiresConfirmation {
+		t.Errorf("new intent has status %q", intent.Status)
+	}
+
+	if _, err := p.Refund(intent.ID); err == nil {
+		t.Error("refunded an intent that was never paid")
+	}
+
+	intent, err = p.Confirm(intent.ID)
+	if err != nil || intent.Status != StatusSucceeded {
+		t.Errorf("confirm: got status %q and error %v", intent.Status, err)
+	}
+
+	intent, err = p.Refund(intent.ID)
+	if err != nil || intent.Status != StatusRefunded {
+		t.Errorf("refund: got status %q and error %v", intent.Status, err)
+	}
+
+	if _, err := p.Confirm("fake_pi_unknown"); !errors.Is(err, ErrUnknownIntent) {
+		t.Errorf("expected ErrUnknownIntent, got %v", err)
+	}
+}
+
+func TestFakeProvider_VerifyWebhook(t *testing.T) {
+	p := NewFakeProvider("secret")
+	payload := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","intent":{"id":"fake_pi_1"}}`)
+
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, p.Sign(payload))
+
+	event, err := p.VerifyWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventSucceeded || event.Intent.ID != "fake_pi_1" {
+		t.Errorf("decoded the wrong event: %+v", event)
+	}
+
+	header.Set(FakeSignatureHeader, NewFakeProvider("other").Sign(payload))
+	if _, err := p.VerifyWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for a foreign signature, got %v", err)
+	}
+
+	header.Del(FakeSignatureHeader)
+	if _, err := p.VerifyWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature without a signature, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Package payments takes the payment of reservations through a PaymentProvider
+package payments
+
+import (
+	"errors"
+	"net/http"
+)
+
+// statuses of an Intent
+const (
+	StatusRequiresConfirmation = "requires_confirmation"
+	StatusSucceeded            = "succeeded"
+	StatusFailed               = "failed"
+	StatusRefunded             = "refunded"
+)
+
+// types of the events providers send to the webhook
+const (
+	EventSucceeded = "payment_intent.succeeded"
+	EventFailed    = "payment_intent.payment_failed"
+	EventRefunded  = "charge.refunded"
+)
+
+// ErrInvalidSignature is returned by VerifyWebhook for a request the provider did not sign
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// ErrUnknownIntent is returned for an intent the provider does not know
+var ErrUnknownIntent = errors.New("unknown payment intent")
+
+// Intent is a payment the provider is asked to collect, amounts are in cents of Currency
+type Intent struct {
+	ID       string `json:"id"`
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	// Reference ties the intent to what is paid for, the id of the reservation
+	Reference string `json:"reference"`
+}
+
+// Event is a notification the provider sent to the webhook
+type Event struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Intent Intent `json:"intent"`
+}
+
+// PaymentProvider is implemented by every payment service the site can take payments with
+type PaymentProvider interface {
+	// CreateIntent starts a payment of amount for reference
+	CreateIntent(amount int, currency, reference string) (Intent, error)
+	// Confirm collects the payment of an intent
+	Confirm(intentID string) (Intent, error)
+	// Refund pays a succeeded intent back in full
+	Refund(intentID string) (Intent, error)
+	// VerifyWebhook checks the signature of a webhook request and decodes its event
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+}
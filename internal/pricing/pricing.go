@@ -0,0 +1,224 @@
+// Package pricing computes what a stay in a room costs from the room's base price and the rates stored in room_rates
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bangn/bookings/internal/models"
+)
+
+// Currency is the currency of every price in the system
+const Currency = "USD"
+
+// ErrInvalidRange is returned for a stay that does not end after it starts
+var ErrInvalidRange = errors.New("the stay must end after it starts")
+
+// errors of ApplyPromoCode, their messages are shown to guests
+var (
+	ErrPromoCodeNotValid   = errors.New("this promo code is not valid at the moment")
+	ErrPromoCodeNotForRoom = errors.New("this promo code can not be used for this room")
+	ErrPromoCodeUsedUp     = errors.New("this promo code has already been used up")
+)
+
+// MinStayError is returned for a stay shorter than one of its rates allows
+type MinStayError struct {
+	MinNights int
+}
+
+func (e *MinStayError) Error() string {
+	return fmt.Sprintf("the stay must last at least %d nights", e.MinNights)
+}
+
+// Night is the price of one night of a stay
+type Night struct {
+	Date  time.Time `json:"date"`
+	Price int       `json:"price"`
+	// Rate is the name of the rate applied, empty for the base price of the room
+	Rate string `json:"rate,omitempty"`
+}
+
+// Quote is the price of a stay, amounts are in cents of Currency
+type Quote struct {
+	RoomID int `json:"room_id"`
+	// RoomIDs are all the rooms priced by a quote put together with Combine
+	RoomIDs   []int     `json:"room_ids,omitempty"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	Nights    []Night   `json:"nights"`
+	// Subtotal is the price of the nights, Total what is left to pay once Discount is taken off
+	Subtotal  int    `json:"subtotal"`
+	Discount  int    `json:"discount"`
+	PromoCode string `json:"promo_code,omitempty"`
+	Total     int    `json:"total"`
+	Currency  string `json:"currency"`
+}
+
+// NewQuote prices every night from start up to, not including, end.
+// Each night uses the most specific rate covering it, see rateFor, or the nightly price of the room when none does
+func NewQuote(room models.Room, start, end time.Time, rates []models.RoomRate) (Quote, error) {
+	if !end.After(start) {
+		return Quote{}, ErrInvalidRange
+	}
+
+	q := Quote{
+		RoomID:    room.ID,
+		StartDate: start,
+		EndDate:   end,
+		Currency:  Currency,
+	}
+
+	minNights := 1
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := Night{Date: d, Price: room.NightlyPrice}
+
+		if rate, ok := rateFor(room.ID, d, rates); ok {
+			night.Rate = rate.Name
+			night.Price = rate.WeekdayPrice
+			if isWeekend(d) && rate.WeekendPrice > 0 {
+				night.Price = rate.WeekendPrice
+			}
+			minNights = max(minNights, rate.MinNights)
+		}
+
+		q.Nights = append(q.Nights, night)
+		q.Subtotal += night.Price
+	}
+
+	if len(q.Nights) < minNights {
+		return Quote{}, &MinStayError{MinNights: minNights}
+	}
+
+	q.Total = q.Subtotal
+	return q, nil
+}
+
+// ApplyPromoCode discounts the quote with promo once it checked the code can be used on the given day for the room.
+// Whether a redemption is still left is only known for sure when booking, see repository.ErrPromoCodeUnavailable
+func ApplyPromoCode(q Quote, promo models.PromoCode, today time.Time) (Quote, error) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	if !promo.ValidFrom.IsZero() && today.Before(promo.ValidFrom) {
+		return q, ErrPromoCodeNotValid
+	}
+	if !promo.ValidUntil.IsZero() && today.After(promo.ValidUntil) {
+		return q, ErrPromoCodeNotValid
+	}
+	if len(promo.RoomIDs) > 0 {
+		for _, roomID := range q.roomIDs() {
+			if !slices.Contains(promo.RoomIDs, roomID) {
+				return q, ErrPromoCodeNotForRoom
+			}
+		}
+	}
+	if promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions {
+		return q, ErrPromoCodeUsedUp
+	}
+
+	return Discount(q, promo), nil
+}
+
+// Combine puts together the quotes of rooms booked for the same stay, each night costs what it costs in all the rooms
+func Combine(quotes ...Quote) Quote {
+	if len(quotes) == 1 {
+		return quotes[0]
+	}
+
+	q := Quote{
+		RoomID:    quotes[0].RoomID,
+		StartDate: quotes[0].StartDate,
+		EndDate:   quotes[0].EndDate,
+		Nights:    slices.Clone(quotes[0].Nights),
+		Currency:  quotes[0].Currency,
+	}
+
+	for i, room := range quotes {
+		q.RoomIDs = append(q.RoomIDs, room.RoomID)
+		q.Subtotal += room.Subtotal
+		if i == 0 {
+			continue
+		}
+
+		for n := range q.Nights {
+			q.Nights[n].Price += room.Nights[n].Price
+			if q.Nights[n].Rate != room.Nights[n].Rate {
+				q.Nights[n].Rate = ""
+			}
+		}
+	}
+
+	q.Total = q.Subtotal
+	return q
+}
+
+// Discount takes promo off the quote without checking whether it can be used,
+// it prices again reservations that redeemed the code already
+func Discount(q Quote, promo models.PromoCode) Quote {
+	switch promo.Kind {
+	case models.PromoPercent:
+		q.Discount = q.Subtotal * min(promo.Value, 100) / 100
+	case models.PromoFixed:
+		q.Discount = min(promo.Value, q.Subtotal)
+	}
+
+	q.PromoCode = promo.Code
+	q.Total = q.Subtotal - q.Discount
+	return q
+}
+
+// roomIDs returns the rooms priced by the quote
+func (q Quote) roomIDs() []int {
+	if len(q.RoomIDs) == 0 {
+		return []int{q.RoomID}
+	}
+	return q.RoomIDs
+}
+
+// rateFor picks the rate of a night: dated rates beat all year ones, rates of the room beat the ones of every room,
+// and among equals the one starting last wins
+func rateFor(roomID int, night time.Time, rates []models.RoomRate) (models.RoomRate, bool) {
+	var best models.RoomRate
+	bestScore := -1
+
+	for _, rate := range rates {
+		if rate.RoomID != 0 && rate.RoomID != roomID {
+			continue
+		}
+		if !covers(rate, night) {
+			continue
+		}
+
+		score := 0
+		if !rate.StartDate.IsZero() || !rate.EndDate.IsZero() {
+			score += 2
+		}
+		if rate.RoomID != 0 {
+			score++
+		}
+
+		if score > bestScore || (score == bestScore && rate.StartDate.After(best.StartDate)) {
+			best = rate
+			bestScore = score
+		}
+	}
+
+	return best, bestScore >= 0
+}
+
+// covers reports whether the night falls between the dates of the rate, a missing date leaves that side open
+func covers(rate models.RoomRate, night time.Time) bool {
+	if !rate.StartDate.IsZero() && night.Before(rate.StartDate) {
+		return false
+	}
+	if !rate.EndDate.IsZero() && !night.Before(rate.EndDate) {
+		return false
+	}
+	return true
+}
+
+// isWeekend reports whether a night is a Friday or Saturday night
+func isWeekend(night time.Time) bool {
+	return night.Weekday() == time.Friday || night.Weekday() == time.Saturday
+}
@@ -0,0 +1,151 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bangn/bookings/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var room = models.Room{ID: 1, NightlyPrice: 10000}
+
+var rates = []models.RoomRate{
+	{ID: 1, Name: "Standard", WeekdayPrice: 11000, WeekendPrice: 15000},
+	{ID: 2, RoomID: 2, Name: "Other room", WeekdayPrice: 1},
+	{ID: 3, Name: "Summer", StartDate: date("2050-07-01"), EndDate: date("2050-09-01"), WeekdayPrice: 20000, MinNights: 3},
+	{ID: 4, RoomID: 1, Name: "Summer suite", StartDate: date("2050-08-01"), EndDate: date("2050-09-01"), WeekdayPrice: 25000, WeekendPrice: 30000, MinNights: 2},
+}
+
+var quoteTests = []struct {
+	name          string
+	rates         []models.RoomRate
+	start         string
+	end           string
+	expectedTotal int
+	minNights     int
+}{
+	// 2050-01-03 is a Monday
+	{"base price", nil, "2050-01-03", "2050-01-05", 20000, 0},
+	{"weekday and weekend rates", rates, "2050-01-06", "2050-01-09", 11000 + 15000 + 15000, 0},
+	{"season for every room", rates, "2050-07-04", "2050-07-07", 3 * 20000, 0},
+	{"season too short", rates, "2050-07-04", "2050-07-06", 0, 3},
+	{"season of the room wins", rates, "2050-08-01", "2050-08-03", 25000 + 25000, 0},
+	{"stay across two rates", rates, "2050-06-29", "2050-07-02", 11000 + 11000 + 20000, 0},
+	{"stay across the end of a season", rates, "2050-08-31", "2050-09-03", 25000 + 11000 + 15000, 0},
+}
+
+func TestNewQuote(t *testing.T) {
+	for _, e := range quoteTests {
+		q, err := NewQuote(room, date(e.start), date(e.end), e.rates)
+
+		if e.minNights > 0 {
+			var minStay *MinStayError
+			if !errors.As(err, &minStay) || minStay.MinNights != e.minNights {
+				t.Errorf("%s: expected a minimum stay of %d nights, got %v", e.name, e.minNights, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+		if q.Total != e.expectedTotal {
+			t.Errorf("%s: got total %d, wanted %d", e.name, q.Total, e.expectedTotal)
+		}
+		if q.Currency != Currency {
+			t.Errorf("%s: got currency %q", e.name, q.Currency)
+		}
+
+		sum := 0
+		for _, n := range q.Nights {
+			sum += n.Price
+		}
+		if sum != q.Total {
+			t.Errorf("%s: the nights add up to %d, not to the total %d", e.name, sum, q.Total)
+		}
+	}
+}
+
+func TestNewQuote_InvalidRange(t *testing.T) {
+	_, err := NewQuote(room, date("2050-01-03"), date("2050-01-03"), nil)
+	if !errors.Is(err, ErrInvalidRange) {
+		t.Errorf("expected ErrInvalidRange, got %v", err)
+	}
+}
+
+var promoTests = []struct {
+	name             string
+	promo            models.PromoCode
+	today            string
+	expectedDiscount int
+	expectedErr      error
+}{
+	{"percent", models.PromoCode{Code: "TEN", Kind: models.PromoPercent, Value: 10}, "2050-01-01", 2000, nil},
+	{"fixed", models.PromoCode{Code: "FIFTY", Kind: models.PromoFixed, Value: 5000}, "2050-01-01", 5000, nil},
+	{"fixed above the price", models.PromoCode{Code: "BIG", Kind: models.PromoFixed, Value: 50000}, "2050-01-01", 20000, nil},
+	{"last valid day", models.PromoCode{Code: "TEN", Kind: models.PromoPercent, Value: 10, ValidUntil: date("2050-01-01")}, "2050-01-01", 2000, nil},
+	{"expired", models.PromoCode{Code: "OLD", Kind: models.PromoPercent, Value: 10, ValidUntil: date("2049-12-31")}, "2050-01-01", 0, ErrPromoCodeNotValid},
+	{"not valid yet", models.PromoCode{Code: "NEW", Kind: models.PromoPercent, Value: 10, ValidFrom: date("2050-02-01")}, "2050-01-01", 0, ErrPromoCodeNotValid},
+	{"other room", models.PromoCode{Code: "ROOM", Kind: models.PromoPercent, Value: 10, RoomIDs: []int{2}}, "2050-01-01", 0, ErrPromoCodeNotForRoom},
+	{"eligible room", models.PromoCode{Code: "ROOM", Kind: models.PromoPercent, Value: 10, RoomIDs: []int{2, 1}}, "2050-01-01", 2000, nil},
+	{"used up", models.PromoCode{Code: "ONCE", Kind: models.PromoPercent, Value: 10, MaxRedemptions: 1, Redemptions: 1}, "2050-01-01", 0, ErrPromoCodeUsedUp},
+}
+
+func TestApplyPromoCode(t *testing.T) {
+	for _, e := range promoTests {
+		q, err := NewQuote(room, date("2050-01-03"), date("2050-01-05"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q, err = ApplyPromoCode(q, e.promo, date(e.today))
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v, got %v", e.name, e.expectedErr, err)
+			continue
+		}
+		if q.Discount != e.expectedDiscount {
+			t.Errorf("%s: got discount %d, wanted %d", e.name, q.Discount, e.expectedDiscount)
+		}
+		if q.Total != q.Subtotal-q.Discount {
+			t.Errorf("%s: total %d is not the subtotal %d less the discount", e.name, q.Total, q.Subtotal)
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	first, err := NewQuote(room, date("2050-01-06"), date("2050-01-08"), rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewQuote(models.Room{ID: 2, NightlyPrice: 8000}, date("2050-01-06"), date("2050-01-08"), rates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := Combine(first, second)
+
+	if q.Total != first.Total+second.Total || q.Subtotal != q.Total {
+		t.Errorf("got total %d, wanted %d", q.Total, first.Total+second.Total)
+	}
+	if len(q.Nights) != 2 {
+		t.Fatalf("got %d nights, wanted 2", len(q.Nights))
+	}
+	if q.Nights[0].Price != 11000+1 || q.Nights[0].Rate != "" {
+		t.Errorf("got first night %+v", q.Nights[0])
+	}
+	if len(q.RoomIDs) != 2 || q.RoomIDs[1] != 2 {
+		t.Errorf("got rooms %v", q.RoomIDs)
+	}
+
+	_, err = ApplyPromoCode(q, models.PromoCode{Code: "ROOM", Kind: models.PromoPercent, Value: 10, RoomIDs: []int{1}}, date("2050-01-01"))
+	if !errors.Is(err, ErrPromoCodeNotForRoom) {
+		t.Errorf("expected ErrPromoCodeNotForRoom for a code of only one of the rooms, got %v", err)
+	}
+}
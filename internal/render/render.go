@@ -4,10 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"path/filepath"
-	"text/template"
+	"strconv"
+	"html/template"
+	"time"
 
 	"github.com/bangn/bookings/internal/config"
 	"github.com/bangn/bookings/internal/models"
@@ -19,7 +20,11 @@ import (
 // app here is global var in this module, not the same app in main
 var app *config.AppConfig
 
-var functions = template.FuncMap{}
+// functions are the helpers usable from inside every template
+var functions = template.FuncMap{
+	"humanDate": HumanDate,
+	"money":     Money,
+}
 
 var pathToTemplates = "./templates"
 
@@ -30,6 +35,28 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// HumanDate formats a date as YYYY-MM-DD
+func HumanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// Money formats an amount in cents as dollars, e.g. 123450 as $1,234.50
+func Money(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	units := strconv.Itoa(cents / 100)
+	// group the thousands
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+
+	return fmt.Sprintf("%s$%s.%02d", sign, units, cents%100)
+}
+
 // AddDefaultData adds default data to all templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	// go can not indentify specific request's context, thus we need to pass the request context to session, 
@@ -42,7 +69,12 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
-	
+
+	// the logged in user is put in session by the login handler, see handlers.PostLogin
+	if app.Session.Exists(r.Context(), "user_id") {
+		td.IsAuthenticated = 1
+		td.User, _ = app.Session.Get(r.Context(), "user").(models.User)
+	}
 	
 	td.CSRFToken = nosurf.Token(r)
 	return td
@@ -76,7 +108,8 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 	// render the template
 	_, err := buffer.WriteTo(w)
 	if err != nil {
-		log.Fatal(">>>>>>Error writing buffer to response writer:", err)
+		// the client went away, there is nobody left to answer
+		app.Logger.ErrorContext(r.Context(), "writing the page", "template", tmpl, "error", err)
 		return err
 	}
 
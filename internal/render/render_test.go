@@ -2,7 +2,10 @@ package render
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bangn/bookings/internal/models"
 )
@@ -24,6 +27,30 @@ func TestAddDefaultData(t *testing.T) {
 }
 
 
+func TestAddDefaultData_User(t *testing.T) {
+	var td models.TemplateData
+	rq, err := getSession()
+	if err != nil {
+		t.Error(err)
+	}
+
+	result := AddDefaultData(&td, rq)
+	if result.IsAuthenticated != 0 {
+		t.Error("anonymous request reported as authenticated")
+	}
+
+	session.Put(rq.Context(), "user_id", 1)
+	session.Put(rq.Context(), "user", models.User{ID: 1, FirstName: "Admin"})
+	result = AddDefaultData(&models.TemplateData{}, rq)
+
+	if result.IsAuthenticated != 1 {
+		t.Error("logged in request not reported as authenticated")
+	}
+	if result.User.FirstName != "Admin" {
+		t.Errorf("expected logged in user Admin, got %q", result.User.FirstName)
+	}
+}
+
 func TestRenderTemplate(t *testing.T) {
 	pathToTemplates = "./../../templates"
 	tc, err := CreateTemplateCache()
@@ -50,6 +77,44 @@ func TestRenderTemplate(t *testing.T) {
 	}
 }
 
+func TestRenderTemplate_EscapesGuestInput(t *testing.T) {
+	pathToTemplates = "./../../templates"
+	tc, err := CreateTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.TemplateCache = tc
+
+	rq, err := getSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+
+	data := make(map[string]interface{})
+	data["reservation"] = models.Reservation{
+		ID:        1,
+		FirstName: "<script>alert(1)</script>",
+		Email:     `"><img src=x onerror=alert(1)>@example.com`,
+		StartDate: time.Now(),
+		EndDate:   time.Now().AddDate(0, 0, 1),
+	}
+
+	err = Template(w, rq, "admin-reservations-show.page.tmpl", &models.TemplateData{Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := w.Body.String()
+	if strings.Contains(body, "<script>alert(1)") || strings.Contains(body, "<img src=x") {
+		t.Errorf("guest input rendered as markup:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Errorf("expected the first name escaped:\n%s", body)
+	}
+}
+
 func TestNewTemplates(t *testing.T) {
 	NewRenderer(app)
 }
@@ -76,4 +141,18 @@ func getSession() (*http.Request, error) {
 	r = r.WithContext(ctx)
 
 	return r, nil
-}
\ No newline at end of file
+}
+func TestMoney(t *testing.T) {
+	for cents, expected := range map[int]string{
+		0:         "$0.00",
+		5:         "$0.05",
+		12000:     "$120.00",
+		123450:    "$1,234.50",
+		123456789: "$1,234,567.89",
+		-9950:     "-$99.50",
+	} {
+		if got := Money(cents); got != expected {
+			t.Errorf("Money(%d) = %q, wanted %q", cents, got, expected)
+		}
+	}
+}
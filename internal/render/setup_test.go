@@ -2,7 +2,6 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
 	"net/http"
 	"os"
 	"testing"
@@ -10,6 +9,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/logging"
 	"github.com/bangn/bookings/internal/models"
 )
 
@@ -22,11 +22,7 @@ func TestMain(m *testing.M) {
 
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "[INFO]\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "[ERROR]\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = logging.New(os.Stdout, testApp.InProduction)
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
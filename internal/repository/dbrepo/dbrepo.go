@@ -1,9 +1,14 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/models"
 	"github.com/bangn/bookings/internal/repository"
 )
 
@@ -12,9 +17,22 @@ type PostgresDBRepo struct {
 	DB *sql.DB
 }
 
+// dbtx is implemented by both *sql.DB and *sql.Tx,
+// so the same statement helpers can run standalone or as part of a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type testDBRepo struct {
 	App *config.AppConfig
 	DB *sql.DB
+
+	// the booking drafts and the reservations booked from them are kept, the handlers read back what they wrote
+	mu           sync.Mutex
+	drafts       map[string]models.BookingDraft
+	reservations map[int]models.Reservation
 }
 
 func NewPostgresRepo(a *config.AppConfig, conn *sql.DB) repository.DatabaseRepo {
@@ -27,5 +45,36 @@ func NewPostgresRepo(a *config.AppConfig, conn *sql.DB) repository.DatabaseRepo
 func NewTestingPostgresRepo(a *config.AppConfig) repository.DatabaseRepo {
 	return &testDBRepo {
 		App: a,
+		drafts: make(map[string]models.BookingDraft),
+		reservations: make(map[int]models.Reservation),
+	}
+}
+
+// defaultQueryTimeout bounds the calls of a repository whose app has no QueryTimeout
+const defaultQueryTimeout = 3 * time.Second
+
+// queryTimeout is the time a repository call gets to run its queries
+func (m *PostgresDBRepo) queryTimeout() time.Duration {
+	if m.App == nil || m.App.QueryTimeout <= 0 {
+		return defaultQueryTimeout
 	}
-}
\ No newline at end of file
+	return m.App.QueryTimeout
+}
+
+// withTimeout bounds a repository call by the query timeout, or less when ctx ends first,
+// so a guest closing the page cancels the queries of their request
+func (m *PostgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return m.withTimeoutOf(ctx, m.queryTimeout())
+}
+
+// withTimeoutOf is withTimeout with a given budget, the returned cancel logs the calls that ran out of time,
+// under the request ID ctx carries
+func (m *PostgresDBRepo) withTimeoutOf(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	return callCtx, func() {
+		if errors.Is(callCtx.Err(), context.DeadlineExceeded) && m.App != nil && m.App.Logger != nil {
+			m.App.Logger.WarnContext(callCtx, "database call ran out of time", "timeout", timeout)
+		}
+		cancel()
+	}
+}
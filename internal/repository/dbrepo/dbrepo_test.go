@@ -0,0 +1,137 @@
+package dbrepo
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bangn/bookings/internal/config"
+	"github.com/bangn/bookings/internal/logging"
+	"github.com/bangn/bookings/internal/models"
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+func TestPostgresDBRepo_WithTimeout(t *testing.T) {
+	var logs bytes.Buffer
+	m := &PostgresDBRepo{App: &config.AppConfig{QueryTimeout: 20 * time.Millisecond, Logger: logging.New(&logs, true)}}
+
+	// the budget of the app bounds the call
+	ctx, cancel := m.withTimeout(context.Background())
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > 20*time.Millisecond {
+		t.Errorf("expected a deadline within the query timeout, got %v", deadline)
+	}
+	<-ctx.Done()
+	cancel()
+	if !strings.Contains(logs.String(), "ran out of time") {
+		t.Errorf("a call running out of time is logged, got %q", logs.String())
+	}
+
+	// the request going away ends the call sooner
+	parent, stop := context.WithCancel(context.Background())
+	ctx, cancel = m.withTimeout(parent)
+	defer cancel()
+	stop()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("expected the call to be cancelled with its request, got %v", ctx.Err())
+	}
+}
+
+func TestPostgresDBRepo_QueryTimeoutDefault(t *testing.T) {
+	m := &PostgresDBRepo{App: &config.AppConfig{}}
+	if m.queryTimeout() != defaultQueryTimeout {
+		t.Errorf("expected the default timeout, got %s", m.queryTimeout())
+	}
+}
+
+func TestPostgresDBRepo_SearchAvailabilityByDatesByRoomId_Error(t *testing.T) {
+	// nothing listens on port 1, the query fails
+	db, err := sql.Open("pgx", "host=127.0.0.1 port=1 connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := &PostgresDBRepo{App: &config.AppConfig{QueryTimeout: time.Second, Logger: logging.New(io.Discard, true)}, DB: db}
+	start := time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	available, err := m.SearchAvailabilityByDatesByRoomId(context.Background(), start, start.AddDate(0, 0, 2), 1)
+	if err == nil || available {
+		t.Errorf("a failed query is an error, not a room taken: got available %v and error %v", available, err)
+	}
+}
+
+func TestNightsLeft(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2050, time.February, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	var tests = []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		date     time.Time
+		expected [][2]time.Time
+	}{
+		{"only night", day(5), day(6), day(5), nil},
+		{"first night", day(20), day(23), day(20), [][2]time.Time{{day(21), day(23)}}},
+		{"last night", day(20), day(23), day(22), [][2]time.Time{{day(20), day(22)}}},
+		{"middle night", day(20), day(23), day(21), [][2]time.Time{{day(20), day(21)}, {day(22), day(23)}}},
+	}
+
+	for _, e := range tests {
+		left := nightsLeft(e.start, e.end, e.date)
+		if len(left) != len(e.expected) {
+			t.Errorf("%s: got %d ranges, wanted %d", e.name, len(left), len(e.expected))
+			continue
+		}
+		for i, rr := range left {
+			if !rr.StartDate.Equal(e.expected[i][0]) || !rr.EndDate.Equal(e.expected[i][1]) {
+				t.Errorf("%s: got %s - %s, wanted %s - %s", e.name, rr.StartDate, rr.EndDate, e.expected[i][0], e.expected[i][1])
+			}
+		}
+	}
+}
+
+func TestReservationFilterWhere(t *testing.T) {
+	where, args := reservationFilterWhere(models.ReservationFilter{})
+	if where != "" || len(args) != 0 {
+		t.Errorf("an empty filter keeps every reservation, got %q with %v", where, args)
+	}
+
+	from := time.Date(2050, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2050, time.January, 31, 0, 0, 0, 0, time.UTC)
+	where, args = reservationFilterWhere(models.ReservationFilter{
+		Status: models.ReservationConfirmed,
+		From:   from,
+		To:     to,
+		RoomID: 2,
+		Guest:  "50%_off",
+	})
+
+	for _, condition := range []string{
+		"r.status = $1",
+		"r.end_date > $2",
+		"r.start_date <= $3",
+		"rr.room_id = $4",
+		"r.first_name || ' ' || r.last_name ILIKE $5 OR r.email ILIKE $5",
+	} {
+		if !strings.Contains(where, condition) {
+			t.Errorf("expected %q in %q", condition, where)
+		}
+	}
+	expected := []interface{}{models.ReservationConfirmed, from, to, 2, `%50\%\_off%`}
+	if len(args) != len(expected) {
+		t.Fatalf("got args %v, wanted %v", args, expected)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Errorf("arg $%d: got %v, wanted %v", i+1, args[i], expected[i])
+		}
+	}
+}
@@ -2,29 +2,166 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/repository"
+	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *PostgresDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user of the system, passwords are left out
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var users []models.User
+
+	query := `
+		SELECT
+			id, first_name, last_name, email, access_level, created_at, updated_at
+		FROM
+			users
+		ORDER BY
+			last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.Created_at,
+			&u.Updated_at,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// GetUserByID returns a user by ID, the password is left out
+func (m *PostgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var u models.User
+
+	query := `
+		SELECT
+			id, first_name, last_name, email, access_level, created_at, updated_at
+		FROM
+			users
+		WHERE
+			id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.AccessLevel,
+		&u.Created_at,
+		&u.Updated_at,
+	)
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// Authenticate checks the email and the plain text password against the bcrypt hash stored in users,
+// it returns repository.ErrInvalidCredentials when they do not match
+func (m *PostgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var u models.User
+	var hashedPassword string
+
+	query := `
+		SELECT
+			id, first_name, last_name, email, password, access_level, created_at, updated_at
+		FROM
+			users
+		WHERE
+			email = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, strings.ToLower(strings.TrimSpace(email)))
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&hashedPassword,
+		&u.AccessLevel,
+		&u.Created_at,
+		&u.Updated_at,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, repository.ErrInvalidCredentials
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return models.User{}, repository.ErrInvalidCredentials
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	return u, nil
 }
 
 
 // InsertReservation inserts a reservation into the database
-func (m *PostgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *PostgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	// create a context with timeout, to avoid long running queries, 
 	// and potential memory leaks
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	return insertReservation(ctx, m.DB, res)
+}
+
+// insertReservation inserts a reservation using q and returns the new ID
+func insertReservation(ctx context.Context, q dbtx, res models.Reservation) (int, error) {
 	var newId int
 	
-	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, token,
+		total, currency, discount, promo_code_id, status, adults, children, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) returning id`
+
+	var promoCodeID sql.NullInt64
+	if res.PromoCodeID != 0 {
+		promoCodeID = sql.NullInt64{Int64: int64(res.PromoCodeID), Valid: true}
+	}
+
+	status := initialStatus(res)
 
-	err :=m.DB.QueryRowContext(
+	err := q.QueryRowContext(
 		ctx,
 		stmt,
 		res.FirstName,
@@ -34,6 +171,14 @@ func (m *PostgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Token,
+		res.Total,
+		res.Currency,
+		res.Discount,
+		promoCodeID,
+		status,
+		res.Adults,
+		res.Children,
 		time.Now(),
 		time.Now(),
 	).Scan(&newId)
@@ -46,14 +191,19 @@ func (m *PostgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *PostgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) (error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *PostgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
+	return insertRoomRestriction(ctx, m.DB, r)
+}
+
+// insertRoomRestriction inserts a room restriction using q
+func insertRoomRestriction(ctx context.Context, q dbtx, r models.RoomRestriction) error {
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at)
 	values ($1, $2, $3, $4, $5, $6, $7)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	_, err := q.ExecContext(ctx, stmt,
 		r.StartDate,
 		r.EndDate,
 		r.RoomID,
@@ -70,10 +220,260 @@ func (m *PostgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) (error)
 	return nil
 }
 
+// BookRoom inserts the reservation and the room restriction of each of its rooms in one transaction.
+// The room rows are locked first, so two guests booking the same room are serialized,
+// then availability is checked again; if the dates got taken, or are held by another booking draft,
+// in the meantime repository.ErrRoomNotAvailable is returned and nothing is written.
+// When the reservation counts its guests, rooms too small for them give repository.ErrOverCapacity.
+// A reservation booked from a draft marks the draft booked, a draft booked already gives repository.ErrBookingDraftBooked
+func (m *PostgresDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	// rolling back a committed transaction is a no-op, so this only undoes failed bookings
+	defer tx.Rollback()
+
+	if res.DraftID != "" {
+		// the lock serializes the submissions of the same form
+		var reservationID sql.NullInt64
+		err = tx.QueryRowContext(ctx, `SELECT reservation_id FROM booking_drafts WHERE id = $1 FOR UPDATE`, res.DraftID).Scan(&reservationID)
+		if err != nil {
+			return 0, err
+		}
+		if reservationID.Valid {
+			return 0, repository.ErrBookingDraftBooked
+		}
+	}
+
+	roomIDs := res.RoomIDs()
+
+	capacity, err := lockRooms(ctx, tx, roomIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, roomID := range roomIDs {
+		available, err := roomIsFreeExcept(ctx, tx, roomID, res.StartDate, res.EndDate, 0, res.DraftID)
+		if err != nil {
+			return 0, err
+		}
+		if !available {
+			return 0, repository.ErrRoomNotAvailable
+		}
+	}
+
+	if res.Guests() > capacity {
+		return 0, repository.ErrOverCapacity
+	}
+
+	newId, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, roomID := range roomIDs {
+		converted, err := convertHold(ctx, tx, res.DraftID, roomID, newId)
+		if err != nil {
+			return 0, err
+		}
+		if !converted {
+			err = insertRoomRestriction(ctx, tx, models.RoomRestriction{
+				StartDate:     res.StartDate,
+				EndDate:       res.EndDate,
+				RoomID:        roomID,
+				ReservationID: newId,
+				RestrictionID: models.RestrictionReservation,
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`insert into reservation_rooms (reservation_id, room_id, created_at, updated_at) values ($1, $2, $3, $4)`,
+			newId, roomID, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if res.PromoCodeID != 0 {
+		err = redeemPromoCode(ctx, tx, res.PromoCodeID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = insertReservationEvent(ctx, tx, models.ReservationEvent{
+		ReservationID: newId,
+		ToStatus:      initialStatus(res),
+		Actor:         models.ActorGuest.Name,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if res.DraftID != "" {
+		_, err = tx.ExecContext(ctx,
+			`update booking_drafts set reservation_id = $1, updated_at = $2 where id = $3`,
+			newId, time.Now(), res.DraftID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// convertHold turns the hold a booking draft has on the room into the restriction of the reservation,
+// so the room is kept without a gap. It reports false when there is no hold to convert, without a draft
+// or once the sweeper removed the hold that expired
+func convertHold(ctx context.Context, tx *sql.Tx, draftID string, roomID, reservationID int) (bool, error) {
+	if draftID == "" {
+		return false, nil
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		update room_restrictions
+		set restriction_id = $1, reservation_id = $2, booking_draft_id = null, expires_at = null, updated_at = $3
+		where booking_draft_id = $4 and room_id = $5`,
+		models.RestrictionReservation, reservationID, time.Now(), draftID, roomID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// initialStatus is the status a reservation is inserted with
+func initialStatus(res models.Reservation) string {
+	if res.Status == "" {
+		return models.ReservationConfirmed
+	}
+	return res.Status
+}
+
+// redeemPromoCode counts one more booking made with the promo code, the check of the limit and the
+// increment are one statement so two bookings can not take the last redemption
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, id int) error {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE promo_codes
+		SET redemptions = redemptions + 1, updated_at = $2
+		WHERE id = $1 AND (max_redemptions = 0 OR redemptions < max_redemptions)`,
+		id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrPromoCodeUnavailable
+	}
+	return nil
+}
+
+// lockRoom takes a row lock on the room until the transaction ends,
+// every write of room_restrictions for a room must hold it
+func lockRoom(ctx context.Context, tx *sql.Tx, roomID int) error {
+	var id int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrRoomNotAvailable
+	}
+	return err
+}
+
+// lockRooms takes the row lock of every room, see lockRoom, and returns how many guests they sleep together.
+// The rooms are locked in the order of their ids so two bookings sharing rooms can not deadlock
+func lockRooms(ctx context.Context, tx *sql.Tx, roomIDs []int) (int, error) {
+	roomIDs = slices.Clone(roomIDs)
+	slices.Sort(roomIDs)
+
+	total := 0
+	for _, roomID := range roomIDs {
+		var capacity int
+		err := tx.QueryRowContext(ctx, `SELECT capacity FROM rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&capacity)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, repository.ErrRoomNotAvailable
+		} else if err != nil {
+			return 0, err
+		}
+		total += capacity
+	}
+
+	return total, nil
+}
+
+// reservationRoomIDs returns the ids of the rooms of a reservation
+func reservationRoomIDs(ctx context.Context, q dbtx, id int) ([]int, error) {
+	var roomIDs []int
+
+	rows, err := q.QueryContext(ctx, `SELECT room_id FROM reservation_rooms WHERE reservation_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return roomIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		if err := rows.Scan(&roomID); err != nil {
+			return roomIDs, err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	return roomIDs, rows.Err()
+}
+
+// liveRestriction is the condition of the room_restrictions rows that keep a room, $1 being now:
+// every restriction but the holds that expired, the sweeper has not removed yet
+const liveRestriction = `(expires_at IS NULL OR expires_at > $1)`
+
+// roomIsFree reports whether no room restriction overlaps start - end for the room
+func roomIsFree(ctx context.Context, q dbtx, roomID int, start, end time.Time) (bool, error) {
+	return roomIsFreeExcept(ctx, q, roomID, start, end, 0, "")
+}
+
+// roomIsFreeExcept is roomIsFree ignoring the restrictions owned by reservationID and the holds of the booking draft draftID
+func roomIsFreeExcept(ctx context.Context, q dbtx, roomID int, start, end time.Time, reservationID int, draftID string) (bool, error) {
+	var numRows int
+
+	query := `
+	SELECT
+		COUNT(id)
+	FROM
+		room_restrictions
+	WHERE
+		` + liveRestriction + ` AND
+		room_id = $2 AND
+		$3 < end_date and $4 > start_date AND
+		(reservation_id IS NULL OR reservation_id <> $5) AND
+		(booking_draft_id IS NULL OR booking_draft_id <> $6);`
+
+	err := q.QueryRowContext(ctx, query, time.Now(), roomID, start, end, reservationID, draftID).Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	return numRows == 0, nil
+}
 
 // SearchAvailabilityByDatesByRoomId
-func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 	var numRows int
 
@@ -83,19 +483,21 @@ func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time,
 	FROM
 		room_restrictions
 	WHERE
-		room_id = $1 AND
-		$2 < end_date and $3 > start_date;`
+		` + liveRestriction + ` AND
+		room_id = $2 AND
+		$3 < end_date and $4 > start_date;`
 
 	row := m.DB.QueryRowContext(
 		ctx,
 		query,
+		time.Now(),
 		roomId,
 		start,
 		end,
 	)
 	err := row.Scan(&numRows)
 	if err != nil {
-		return false, nil
+		return false, err
 	}
 
 	if numRows == 0 {
@@ -106,70 +508,1693 @@ func (m *PostgresDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time,
 }
 
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *PostgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range.
+// Rooms held for another guest are left out until their hold expires
+func (m *PostgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	var rooms []models.Room
 
-	query := `
-	SELECT
-		r.id, r.room_name
-	FROM
-		roomS r
+	query := roomSelect + `
 	WHERE r.id not in
-		(SELECT room_id FROM room_restrictions rr WHERE $1 < rr.end_date AND $2 > rr.start_date);`
+		(SELECT room_id FROM room_restrictions rr WHERE ` + liveRestriction + ` AND $2 < rr.end_date AND $3 > rr.start_date)
+	ORDER BY r.room_name`
 
-	rows, err := m.DB.QueryContext(
-		ctx,
-		query,
-		start,
-		end,
+	return m.queryRooms(ctx, query, time.Now(), start, end)
+}
+
+// GetRoomByID gets a room by ID
+func (m *PostgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.getRoom(ctx, roomSelect+` WHERE r.id = $1`, id)
+}
+// reservationSelect is the column list every reservation query scans with scanReservation
+const reservationSelect = `
+		SELECT
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.token, r.cancelled_at,
+			r.total, r.currency, r.discount, coalesce(r.promo_code_id, 0), r.status,
+			coalesce(r.payment_intent_id, ''), r.adults, r.children, r.created_at, r.updated_at,
+			rm.id, rm.room_name
+		FROM
+			reservations r
+			LEFT JOIN rooms rm ON (r.room_id = rm.id)`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanReservation scans one row selected with reservationSelect
+func scanReservation(row rowScanner, res *models.Reservation) error {
+	var cancelledAt sql.NullTime
+
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.Token,
+		&cancelledAt,
+		&res.Total,
+		&res.Currency,
+		&res.Discount,
+		&res.PromoCodeID,
+		&res.Status,
+		&res.PaymentIntentID,
+		&res.Adults,
+		&res.Children,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Room.ID,
+		&res.Room.RoomName,
 	)
 	if err != nil {
-		return rooms, err
+		return err
+	}
+
+	res.CancelledAt = cancelledAt.Time
+	return nil
+}
+
+// AllReservations returns a slice of the reservations filter keeps, newest first
+func (m *PostgresDBRepo) AllReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	where, args := reservationFilterWhere(filter)
+	query := reservationSelect + where + `
+		ORDER BY
+			r.start_date DESC`
+
+	return m.queryReservations(ctx, query, args...)
+}
+
+// AllNewReservations returns a slice of the reservations filter keeps among those waiting for staff to confirm them
+func (m *PostgresDBRepo) AllNewReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	filter.Status = models.ReservationNew
+	where, args := reservationFilterWhere(filter)
+	query := reservationSelect + where + `
+		ORDER BY
+			r.start_date ASC`
+
+	return m.queryReservations(ctx, query, args...)
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so they match themselves
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// reservationFilterWhere returns the WHERE clause keeping the reservations of filter, for reservationSelect,
+// with its args. The guest is matched anywhere in the name or the email, whatever the case
+func reservationFilterWhere(filter models.ReservationFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.Status != "" {
+		add("r.status = ?", filter.Status)
 	}
+	if !filter.From.IsZero() {
+		add("r.end_date > ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("r.start_date <= ?", filter.To)
+	}
+	if filter.RoomID != 0 {
+		add("EXISTS (SELECT 1 FROM reservation_rooms rr WHERE rr.reservation_id = r.id AND rr.room_id = ?)", filter.RoomID)
+	}
+	if filter.Guest != "" {
+		add("(r.first_name || ' ' || r.last_name ILIKE ? OR r.email ILIKE ?)", "%"+likeEscaper.Replace(filter.Guest)+"%")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return `
+		WHERE
+			` + strings.Join(conditions, `
+			AND `), args
+}
+
+// queryReservations runs a reservation listing query and scans every row
+func (m *PostgresDBRepo) queryReservations(ctx context.Context, query string, args ...interface{}) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
+		var i models.Reservation
+		err := scanReservation(rows, &i)
 		if err != nil {
-			return rooms, err
+			return reservations, err
 		}
+		reservations = append(reservations, i)
+	}
 
-		rooms = append(rooms, room)
+	if err = rows.Err(); err != nil {
+		return reservations, err
 	}
 
-	return  rooms, nil
+	return reservations, nil
 }
 
-// GetRoomByID gets a room by ID
-func (m *PostgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	var room models.Room
+// getReservation reads the single reservation selected by query, with all its rooms
+func (m *PostgresDBRepo) getReservation(ctx context.Context, query string, args ...interface{}) (models.Reservation, error) {
+	var res models.Reservation
 
-	query := `
-		SELECT 
-			id, room_name, created_at, updated_at
-		FROM
-			rooms
-		WHERE
-			id = $1`
-	
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
+	err := scanReservation(m.DB.QueryRowContext(ctx, query, args...), &res)
+	if err != nil {
+		return res, err
+	}
 
+	res.Rooms, err = m.queryRooms(ctx, roomSelect+`
+		JOIN reservation_rooms rr ON (rr.room_id = r.id)
+		WHERE
+			rr.reservation_id = $1
+		ORDER BY
+			rr.id`, res.ID)
 	if err != nil {
-		return room, err
+		return res, err
 	}
 
-	return room, nil
-}
\ No newline at end of file
+	return res, nil
+}
+
+// GetReservationByID returns one reservation, together with its rooms, by ID
+func (m *PostgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := reservationSelect + `
+		WHERE
+			r.id = $1`
+
+	return m.getReservation(ctx, query, id)
+}
+
+// GetReservationByToken returns one reservation, together with its rooms, by its guest token
+func (m *PostgresDBRepo) GetReservationByToken(ctx context.Context, token string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := reservationSelect + `
+		WHERE
+			r.token = $1`
+
+	return m.getReservation(ctx, query, token)
+}
+
+// ChangeReservationDates moves a reservation, and the room restrictions it owns, to new dates priced at total.
+// Like BookRoom it locks the rooms and re-checks availability, ignoring the reservation's own nights;
+// repository.ErrRoomNotAvailable is returned if the new dates are taken or held for another guest,
+// repository.ErrReservationNotChangeable if the reservation was cancelled or refunded and
+// repository.ErrReservationPaid if the payment of the reservation was taken
+func (m *PostgresDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, total, discount int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the reservation may have been cancelled, or its payment taken, since the guest opened the page
+	var res models.Reservation
+	err = tx.QueryRowContext(ctx,
+		`select status, coalesce(payment_intent_id, '') from reservations where id = $1 for update`,
+		id).Scan(&res.Status, &res.PaymentIntentID)
+	if err != nil {
+		return err
+	}
+	if !res.CanBeChanged() {
+		return repository.ErrReservationNotChangeable
+	}
+	if res.IsPaid() {
+		return repository.ErrReservationPaid
+	}
+
+	roomIDs, err := reservationRoomIDs(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = lockRooms(ctx, tx, roomIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, roomID := range roomIDs {
+		available, err := roomIsFreeExcept(ctx, tx, roomID, start, end, id, "")
+		if err != nil {
+			return err
+		}
+		if !available {
+			return repository.ErrRoomNotAvailable
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`update reservations set start_date = $1, end_date = $2, total = $3, discount = $4, updated_at = $5,
+			payment_intent_id = case when status = $7 then null else payment_intent_id end
+		where id = $6`,
+		start, end, total, discount, time.Now(), id, models.ReservationPendingPayment)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 where reservation_id = $4`,
+		start, end, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation marks a reservation as cancelled and deletes its room restrictions,
+// so the room can be booked again for those nights
+func (m *PostgresDBRepo) CancelReservation(ctx context.Context, id int, actor models.Actor) error {
+	return m.UpdateReservationStatus(ctx, id, models.ReservationCancelled, actor)
+}
+
+// UpdateReservationStatus moves a reservation to another status, see changeReservationStatus
+func (m *PostgresDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string, actor models.Actor) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	from, err := lockReservationStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = changeReservationStatus(ctx, tx, id, from, status, actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockReservationStatus returns the status of a reservation and locks its row until the transaction ends
+func lockReservationStatus(ctx context.Context, tx *sql.Tx, id int) (string, error) {
+	var status string
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&status)
+	return status, err
+}
+
+// changeReservationStatus is the only place the status of a reservation changes: it refuses transitions
+// models.CanTransition does not allow, frees the room of cancelled and refunded reservations and records
+// the change in reservation_events. The row must have been locked with lockReservationStatus
+func changeReservationStatus(ctx context.Context, tx *sql.Tx, id int, from, to string, actor models.Actor) error {
+	if !models.CanTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", repository.ErrInvalidStatusTransition, from, to)
+	}
+
+	now := time.Now()
+
+	if to == models.ReservationCancelled || to == models.ReservationRefunded {
+		_, err := tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		update reservations set status = $1, updated_at = $2,
+			cancelled_at = case when $1 in ('cancelled', 'refunded') then coalesce(cancelled_at, $2) else cancelled_at end
+		where id = $3`,
+		to, now, id)
+	if err != nil {
+		return err
+	}
+
+	return insertReservationEvent(ctx, tx, models.ReservationEvent{
+		ReservationID: id,
+		FromStatus:    from,
+		ToStatus:      to,
+		Actor:         actor.Name,
+		UserID:        actor.UserID,
+		CreatedAt:     now,
+	})
+}
+
+// insertReservationEvent records one change of the status of a reservation
+func insertReservationEvent(ctx context.Context, q dbtx, e models.ReservationEvent) error {
+	var userID sql.NullInt64
+	if e.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+
+	_, err := q.ExecContext(ctx, `
+		insert into reservation_events (reservation_id, from_status, to_status, actor, user_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $6)`,
+		e.ReservationID, e.FromStatus, e.ToStatus, e.Actor, userID, e.CreatedAt)
+	return err
+}
+
+// GetReservationEvents returns the history of the statuses of a reservation, oldest first
+func (m *PostgresDBRepo) GetReservationEvents(ctx context.Context, id int) ([]models.ReservationEvent, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var events []models.ReservationEvent
+
+	rows, err := m.DB.QueryContext(ctx, `
+		SELECT
+			e.id, e.reservation_id, e.from_status, e.to_status, e.actor, coalesce(e.user_id, 0), e.created_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		FROM
+			reservation_events e
+			LEFT JOIN users u ON (e.user_id = u.id)
+		WHERE
+			e.reservation_id = $1
+		ORDER BY
+			e.created_at, e.id`, id)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ReservationEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.ReservationID,
+			&e.FromStatus,
+			&e.ToStatus,
+			&e.Actor,
+			&e.UserID,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+		)
+		if err != nil {
+			return events, err
+		}
+		e.User.ID = e.UserID
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// SetReservationPaymentIntent stores the payment intent created for a reservation
+func (m *PostgresDBRepo) SetReservationPaymentIntent(ctx context.Context, id int, intentID string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		`update reservations set payment_intent_id = $1, updated_at = $2 where id = $3`,
+		intentID, time.Now(), id)
+	return err
+}
+
+// GetReservationByPaymentIntent returns the reservation a payment intent was created for
+func (m *PostgresDBRepo) GetReservationByPaymentIntent(ctx context.Context, intentID string) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.getReservation(ctx, reservationSelect+` WHERE r.payment_intent_id = $1`, intentID)
+}
+
+// ConfirmReservationPayment confirms a reservation waiting for its payment.
+// It reports false when the reservation was not pending, e.g. it expired or was confirmed already
+func (m *PostgresDBRepo) ConfirmReservationPayment(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	from, err := lockReservationStatus(ctx, tx, id)
+	if err != nil || from != models.ReservationPendingPayment {
+		return false, err
+	}
+
+	err = changeReservationStatus(ctx, tx, id, from, models.ReservationConfirmed, models.ActorPayments)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// RefundReservation marks the payment of a reservation as refunded, which also cancels it and frees its room
+func (m *PostgresDBRepo) RefundReservation(ctx context.Context, id int, actor models.Actor) error {
+	return m.UpdateReservationStatus(ctx, id, models.ReservationRefunded, actor)
+}
+
+// ExpirePendingReservations cancels the reservations created before the given time that are still not paid,
+// and frees their rooms. It returns the number of reservations cancelled
+func (m *PostgresDBRepo) ExpirePendingReservations(ctx context.Context, createdBefore time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// skip the rows a payment is being confirmed for, they are not expired anyway
+	rows, err := tx.QueryContext(ctx, `
+		select id from reservations
+		where status = $1 and created_at < $2
+		for update skip locked`,
+		models.ReservationPendingPayment, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		err = changeReservationStatus(ctx, tx, id, models.ReservationPendingPayment, models.ReservationCancelled, models.ActorSystem)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), tx.Commit()
+}
+
+// AllRooms returns every room, ordered by name
+func (m *PostgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rooms, err := m.queryRooms(ctx, roomSelect+` ORDER BY r.room_name`)
+	if err != nil {
+		return rooms, err
+	}
+
+	err = m.loadRoomImages(ctx, rooms)
+	if err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// roomSelect is the column list every room query scans with scanRoom
+const roomSelect = `
+		SELECT
+			r.id, r.room_name, r.slug, r.description, r.capacity, r.nightly_price,
+			r.calendar_token, r.created_at, r.updated_at
+		FROM
+			rooms r`
+
+// scanRoom scans one row selected with roomSelect
+func scanRoom(row rowScanner, room *models.Room) error {
+	return row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.NightlyPrice,
+		&room.CalendarToken,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+}
+
+// queryRooms runs a query selecting roomSelect and scans every row, without the images
+func (m *PostgresDBRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error) {
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+		if err := scanRoom(rows, &rm); err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// getRoom reads the single room selected by query, with its images
+func (m *PostgresDBRepo) getRoom(ctx context.Context, query string, args ...interface{}) (models.Room, error) {
+	var room models.Room
+
+	err := scanRoom(m.DB.QueryRowContext(ctx, query, args...), &room)
+	if err != nil {
+		return room, err
+	}
+
+	rooms := []models.Room{room}
+	err = m.loadRoomImages(ctx, rooms)
+	if err != nil {
+		return room, err
+	}
+
+	return rooms[0], nil
+}
+
+// loadRoomImages fills the Images of every room with one query
+func (m *PostgresDBRepo) loadRoomImages(ctx context.Context, rooms []models.Room) error {
+	if len(rooms) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(rooms))
+	ids := make([]int, 0, len(rooms))
+	for i, rm := range rooms {
+		index[rm.ID] = i
+		ids = append(ids, rm.ID)
+	}
+
+	query := `
+		SELECT id, room_id, path, position
+		FROM room_images
+		WHERE room_id = ANY($1)
+		ORDER BY position, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var img models.RoomImage
+		err := rows.Scan(&img.ID, &img.RoomID, &img.Path, &img.Position)
+		if err != nil {
+			return err
+		}
+		i := index[img.RoomID]
+		rooms[i].Images = append(rooms[i].Images, img)
+	}
+
+	return rows.Err()
+}
+
+// GetRoomBySlug gets the room published under /rooms/{slug}
+func (m *PostgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.getRoom(ctx, roomSelect+` WHERE r.slug = $1`, slug)
+}
+
+// InsertRoom adds a room to the catalogue together with its images
+func (m *PostgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, slug, description, capacity, nightly_price, calendar_token, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		room.NightlyPrice,
+		room.CalendarToken,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = insertRoomImages(ctx, tx, newID, room.Images)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom saves the details of a room, its images are replaced by room.Images
+func (m *PostgresDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4, nightly_price = $5, updated_at = $6
+	where id = $7`
+
+	result, err := tx.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		room.NightlyPrice,
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_images where room_id = $1`, room.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertRoomImages(ctx, tx, room.ID, room.Images)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertRoomImages stores the images of a room, in the order given
+func insertRoomImages(ctx context.Context, q dbtx, roomID int, images []models.RoomImage) error {
+	stmt := `insert into room_images (room_id, path, position, created_at, updated_at)
+	values ($1, $2, $3, $4, $5)`
+
+	for i, img := range images {
+		_, err := q.ExecContext(ctx, stmt, roomID, img.Path, i, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteRoom removes a room from the catalogue. Deleting would cascade to its reservations,
+// so rooms that have any return repository.ErrRoomHasReservations instead
+func (m *PostgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, id)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		return sql.ErrNoRows
+	} else if err != nil {
+		return err
+	}
+
+	var reservations int
+	err = tx.QueryRowContext(ctx, `select count(*) from reservations where room_id = $1`, id).Scan(&reservations)
+	if err != nil {
+		return err
+	}
+	if reservations > 0 {
+		return repository.ErrRoomHasReservations
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRoomCalendarToken replaces the key of the calendar feed of a room, the old feed URL stops working
+func (m *PostgresDBRepo) SetRoomCalendarToken(ctx context.Context, id int, token string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`update rooms set calendar_token = $1, updated_at = $2 where id = $3`,
+		token, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRestrictionsForRoomByDate returns the restrictions of a room overlapping start - end,
+// with the name of the restriction type filled in. Holds that expired are left out
+func (m *PostgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		SELECT
+			rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0),
+			rr.restriction_id, r.restriction_name, coalesce(res.first_name, ''), coalesce(res.last_name, ''),
+			rr.source, coalesce(rr.calendar_feed_id, 0), coalesce(rr.external_uid, ''), rr.expires_at, rr.updated_at
+		FROM
+			room_restrictions rr
+			LEFT JOIN restrictions r ON (rr.restriction_id = r.id)
+			LEFT JOIN reservations res ON (rr.reservation_id = res.id)
+		WHERE
+			rr.room_id = $1 AND
+			$2 < rr.end_date AND $3 > rr.start_date AND
+			(rr.expires_at IS NULL OR rr.expires_at > $4)
+		ORDER BY
+			rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end, time.Now())
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rr models.RoomRestriction
+		var expiresAt sql.NullTime
+		err := rows.Scan(
+			&rr.ID,
+			&rr.StartDate,
+			&rr.EndDate,
+			&rr.RoomID,
+			&rr.ReservationID,
+			&rr.RestrictionID,
+			&rr.Restrictions.RestrictionName,
+			&rr.Reservations.FirstName,
+			&rr.Reservations.LastName,
+			&rr.Source,
+			&rr.CalendarFeedID,
+			&rr.ExternalUID,
+			&expiresAt,
+			&rr.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		rr.ExpiresAt = expiresAt.Time
+		rr.Restrictions.ID = rr.RestrictionID
+		rr.Reservations.ID = rr.ReservationID
+		restrictions = append(restrictions, rr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom blocks one night of a room for the owner.
+// The room is locked like in BookRoom, a night already taken returns repository.ErrRoomNotAvailable
+func (m *PostgresDBRepo) InsertBlockForRoom(ctx context.Context, roomID int, date time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, roomID)
+	if err != nil {
+		return err
+	}
+
+	end := date.AddDate(0, 0, 1)
+
+	available, err := roomIsFree(ctx, tx, roomID, date, end)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return repository.ErrRoomNotAvailable
+	}
+
+	// owner blocks do not belong to a reservation, so reservation_id stays null
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6)`
+
+	_, err = tx.ExecContext(ctx, stmt, date, end, roomID, models.RestrictionOwnerBlock, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBlockNight releases the night of date from an owner block, the other nights of the block stay blocked:
+// the block is shortened, split in two around the night or removed when it was its only night.
+// Restrictions of reservations are left alone and so are imported blocks, the next sync of their feed would bring them back
+func (m *PostgresDBRepo) DeleteBlockNight(ctx context.Context, id int, date time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	blockQuery := `select room_id, start_date, end_date from room_restrictions
+		where id = $1 and restriction_id = $2 and calendar_feed_id is null`
+
+	var block models.RoomRestriction
+	err = tx.QueryRowContext(ctx, blockQuery, id, models.RestrictionOwnerBlock).Scan(&block.RoomID, &block.StartDate, &block.EndDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = lockRoom(ctx, tx, block.RoomID)
+	if err != nil {
+		return err
+	}
+
+	// read the block again under the lock, another change of the calendar may have cut it since
+	err = tx.QueryRowContext(ctx, blockQuery, id, models.RestrictionOwnerBlock).Scan(&block.RoomID, &block.StartDate, &block.EndDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if date.Before(block.StartDate) || !date.Before(block.EndDate) {
+		return nil
+	}
+
+	left := nightsLeft(block.StartDate, block.EndDate, date)
+	if len(left) == 0 {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// the block keeps the nights after date, the calendar releases nights in date order and the later ones
+	// still name this block
+	later := left[len(left)-1]
+	_, err = tx.ExecContext(ctx,
+		`update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 where id = $4`,
+		later.StartDate, later.EndDate, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if len(left) == 2 {
+		_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6)`,
+			left[0].StartDate, left[0].EndDate, block.RoomID, models.RestrictionOwnerBlock, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// nightsLeft returns what is left of the nights from start up to end once the night of date is taken out:
+// nothing, the nights before or after it, or both
+func nightsLeft(start, end, date time.Time) []models.RoomRestriction {
+	var left []models.RoomRestriction
+	if start.Before(date) {
+		left = append(left, models.RoomRestriction{StartDate: start, EndDate: date})
+	}
+	if next := date.AddDate(0, 0, 1); next.Before(end) {
+		left = append(left, models.RoomRestriction{StartDate: next, EndDate: end})
+	}
+	return left
+}
+
+// calendarFeedSelect is the column list every calendar feed query scans with scanCalendarFeed
+const calendarFeedSelect = `
+		SELECT
+			id, room_id, name, url, last_synced_at, last_error, created_at, updated_at
+		FROM
+			room_calendar_feeds`
+
+// scanCalendarFeed scans one row selected with calendarFeedSelect
+func scanCalendarFeed(row rowScanner, feed *models.RoomCalendarFeed) error {
+	var lastSyncedAt sql.NullTime
+
+	err := row.Scan(
+		&feed.ID,
+		&feed.RoomID,
+		&feed.Name,
+		&feed.URL,
+		&lastSyncedAt,
+		&feed.LastError,
+		&feed.CreatedAt,
+		&feed.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	feed.LastSyncedAt = lastSyncedAt.Time
+	return nil
+}
+
+// queryCalendarFeeds returns the calendar feeds selected by query
+func (m *PostgresDBRepo) queryCalendarFeeds(ctx context.Context, query string, args ...interface{}) ([]models.RoomCalendarFeed, error) {
+	var feeds []models.RoomCalendarFeed
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.RoomCalendarFeed
+		if err := scanCalendarFeed(rows, &f); err != nil {
+			return feeds, err
+		}
+		feeds = append(feeds, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+
+	return feeds, nil
+}
+
+// AllRoomCalendarFeeds returns the calendar feeds of every room
+func (m *PostgresDBRepo) AllRoomCalendarFeeds(ctx context.Context) ([]models.RoomCalendarFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.queryCalendarFeeds(ctx, calendarFeedSelect+` ORDER BY room_id, id`)
+}
+
+// GetRoomCalendarFeeds returns the calendar feeds of a room
+func (m *PostgresDBRepo) GetRoomCalendarFeeds(ctx context.Context, roomID int) ([]models.RoomCalendarFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.queryCalendarFeeds(ctx, calendarFeedSelect+` WHERE room_id = $1 ORDER BY id`, roomID)
+}
+
+// GetRoomCalendarFeedByID returns a calendar feed by its id
+func (m *PostgresDBRepo) GetRoomCalendarFeedByID(ctx context.Context, id int) (models.RoomCalendarFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var feed models.RoomCalendarFeed
+
+	err := scanCalendarFeed(m.DB.QueryRowContext(ctx, calendarFeedSelect+` WHERE id = $1`, id), &feed)
+	if err != nil {
+		return feed, err
+	}
+
+	return feed, nil
+}
+
+// InsertRoomCalendarFeed adds a calendar feed to a room, its blocks are imported by the next sync
+func (m *PostgresDBRepo) InsertRoomCalendarFeed(ctx context.Context, feed models.RoomCalendarFeed) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_calendar_feeds (room_id, name, url, created_at, updated_at)
+	values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		feed.RoomID,
+		feed.Name,
+		feed.URL,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteRoomCalendarFeed removes a calendar feed, the blocks imported from it go with it
+func (m *PostgresDBRepo) DeleteRoomCalendarFeed(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_calendar_feeds where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceImportedBlocks makes the blocks imported from a feed match blocks, the events it lists now:
+// blocks of known events get their dates updated, new events are blocked and blocks of events
+// the feed dropped are removed. Imported blocks are written even over nights booked here,
+// the room is taken on the other platform anyway, and they are counted as conflicts
+func (m *PostgresDBRepo) ReplaceImportedBlocks(ctx context.Context, feed models.RoomCalendarFeed, blocks []models.RoomRestriction) (models.ImportResult, error) {
+	// a calendar is many statements in one transaction, it gets a few times the budget of a call
+	ctx, cancel := m.withTimeoutOf(ctx, 4*m.queryTimeout())
+	defer cancel()
+
+	var result models.ImportResult
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, feed.RoomID)
+	if err != nil {
+		return result, err
+	}
+
+	existing := make(map[string]models.RoomRestriction)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, external_uid, start_date, end_date
+		FROM room_restrictions
+		WHERE calendar_feed_id = $1`, feed.ID)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var rr models.RoomRestriction
+		if err := rows.Scan(&rr.ID, &rr.ExternalUID, &rr.StartDate, &rr.EndDate); err != nil {
+			rows.Close()
+			return result, err
+		}
+		existing[rr.ExternalUID] = rr
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, b := range blocks {
+		old, ok := existing[b.ExternalUID]
+		delete(existing, b.ExternalUID)
+
+		if ok {
+			if old.StartDate.Equal(b.StartDate) && old.EndDate.Equal(b.EndDate) {
+				continue
+			}
+			_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 where id = $4`,
+				b.StartDate, b.EndDate, time.Now(), old.ID)
+			if err != nil {
+				return result, err
+			}
+			result.Updated++
+			continue
+		}
+
+		stmt := `insert into room_restrictions
+			(start_date, end_date, room_id, restriction_id, source, calendar_feed_id, external_uid, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+		_, err = tx.ExecContext(ctx, stmt,
+			b.StartDate,
+			b.EndDate,
+			feed.RoomID,
+			models.RestrictionOwnerBlock,
+			models.SourceICal,
+			feed.ID,
+			b.ExternalUID,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return result, err
+		}
+		result.Added++
+	}
+
+	// what is left was dropped from the feed, the booking was cancelled or is over
+	for _, old := range existing {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, old.ID)
+		if err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT count(DISTINCT b.id)
+		FROM
+			room_restrictions b
+			JOIN room_restrictions r ON (r.room_id = b.room_id AND r.restriction_id = $2)
+		WHERE
+			b.calendar_feed_id = $1 AND
+			r.start_date < b.end_date AND r.end_date > b.start_date`,
+		feed.ID, models.RestrictionReservation).Scan(&result.Conflicts)
+	if err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+// SetRoomCalendarFeedSynced records when a calendar feed was last synced, syncErr is empty when it worked
+func (m *PostgresDBRepo) SetRoomCalendarFeedSynced(ctx context.Context, id int, syncedAt time.Time, syncErr string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update room_calendar_feeds set last_synced_at = $1, last_error = $2, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, syncedAt, syncErr, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// apiKeySelect is the column list every API key query scans with scanAPIKey
+const apiKeySelect = `
+		SELECT
+			k.id, k.user_id, k.name, k.prefix, k.scope, k.last_used_at, k.revoked_at,
+			k.created_at, k.updated_at,
+			u.id, u.first_name, u.last_name, u.email, u.access_level
+		FROM
+			api_keys k
+			JOIN users u ON (k.user_id = u.id)`
+
+// scanAPIKey scans one row selected with apiKeySelect
+func scanAPIKey(row rowScanner, key *models.APIKey) error {
+	var lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Scope,
+		&lastUsedAt,
+		&revokedAt,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.User.ID,
+		&key.User.FirstName,
+		&key.User.LastName,
+		&key.User.Email,
+		&key.User.AccessLevel,
+	)
+	if err != nil {
+		return err
+	}
+
+	key.LastUsedAt = lastUsedAt.Time
+	key.RevokedAt = revokedAt.Time
+	return nil
+}
+
+// AllAPIKeys returns every API key with its user, newest first
+func (m *PostgresDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	rows, err := m.DB.QueryContext(ctx, apiKeySelect+` ORDER BY k.created_at DESC`)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k models.APIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, nil
+}
+
+// InsertAPIKey stores a new API key, keyHash is the hash of the key handed to the user
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey, keyHash string) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var newId int
+
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scope, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		keyHash,
+		key.Scope,
+		time.Now(),
+		time.Now(),
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// GetAPIKeyByHash returns the active API key with the given hash, and records that it was used.
+// Revoked and unknown keys return sql.ErrNoRows
+func (m *PostgresDBRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var key models.APIKey
+
+	err := scanAPIKey(m.DB.QueryRowContext(ctx, apiKeySelect+`
+		WHERE
+			k.key_hash = $1 AND k.revoked_at IS NULL`, keyHash), &key)
+	if err != nil {
+		return key, err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, time.Now(), key.ID)
+	if err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// RevokeAPIKey revokes an API key, it can not be used any more afterwards
+func (m *PostgresDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRatesForRoom returns the rates of the room, and of every room, that cover at least one night between start and end
+func (m *PostgresDBRepo) GetRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRate, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var rates []models.RoomRate
+
+	query := `
+		SELECT
+			id, coalesce(room_id, 0), name, start_date, end_date, weekday_price, weekend_price, min_nights,
+			created_at, updated_at
+		FROM
+			room_rates
+		WHERE
+			(room_id IS NULL OR room_id = $1)
+			AND (start_date IS NULL OR start_date < $3)
+			AND (end_date IS NULL OR end_date > $2)`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate models.RoomRate
+		var startDate, endDate sql.NullTime
+
+		err := rows.Scan(
+			&rate.ID,
+			&rate.RoomID,
+			&rate.Name,
+			&startDate,
+			&endDate,
+			&rate.WeekdayPrice,
+			&rate.WeekendPrice,
+			&rate.MinNights,
+			&rate.CreatedAt,
+			&rate.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+
+		rate.StartDate = startDate.Time
+		rate.EndDate = endDate.Time
+		rates = append(rates, rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// promoCodeSelect is the column list every promo code query scans with getPromoCode
+const promoCodeSelect = `
+		SELECT
+			id, code, description, kind, value, valid_from, valid_until, max_redemptions, redemptions,
+			created_at, updated_at
+		FROM
+			promo_codes`
+
+// getPromoCode returns the promo code matched by the where clause, with the rooms it can be used for
+func (m *PostgresDBRepo) getPromoCode(ctx context.Context, where string, arg interface{}) (models.PromoCode, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var promo models.PromoCode
+	var validFrom, validUntil sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, promoCodeSelect+` WHERE `+where, arg).Scan(
+		&promo.ID,
+		&promo.Code,
+		&promo.Description,
+		&promo.Kind,
+		&promo.Value,
+		&validFrom,
+		&validUntil,
+		&promo.MaxRedemptions,
+		&promo.Redemptions,
+		&promo.CreatedAt,
+		&promo.UpdatedAt,
+	)
+	if err != nil {
+		return promo, err
+	}
+	promo.ValidFrom = validFrom.Time
+	promo.ValidUntil = validUntil.Time
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT room_id FROM promo_code_rooms WHERE promo_code_id = $1 ORDER BY room_id`, promo.ID)
+	if err != nil {
+		return promo, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		if err := rows.Scan(&roomID); err != nil {
+			return promo, err
+		}
+		promo.RoomIDs = append(promo.RoomIDs, roomID)
+	}
+
+	return promo, rows.Err()
+}
+
+// GetPromoCodeByCode returns the promo code with the given code, codes are matched case insensitively.
+// The unique index on upper(code) keeps two codes from differing only by case, and serves this lookup
+func (m *PostgresDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	return m.getPromoCode(ctx, `upper(code) = upper($1)`, code)
+}
+
+// GetPromoCodeByID returns one promo code
+func (m *PostgresDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	return m.getPromoCode(ctx, `id = $1`, id)
+}
+
+// InsertBookingDraft saves a new booking draft, with a hold on its rooms when it has some.
+// Rooms already held for another guest or booked for the dates give repository.ErrRoomNotAvailable
+func (m *PostgresDBRepo) InsertBookingDraft(ctx context.Context, draft models.BookingDraft) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into booking_drafts (id, start_date, end_date, adults, children, expires_at, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		draft.ID,
+		draft.StartDate,
+		draft.EndDate,
+		draft.Adults,
+		draft.Children,
+		draft.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	err = holdRooms(ctx, tx, draft.ID, draft.RoomIDs, draft.StartDate, draft.EndDate, draft.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetBookingDraft returns a booking draft with its rooms, expired drafts are returned until they are deleted
+func (m *PostgresDBRepo) GetBookingDraft(ctx context.Context, id string) (models.BookingDraft, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var draft models.BookingDraft
+	var reservationID sql.NullInt64
+
+	query := `
+		SELECT
+			id, start_date, end_date, adults, children, reservation_id, expires_at, created_at, updated_at
+		FROM
+			booking_drafts
+		WHERE
+			id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&draft.ID,
+		&draft.StartDate,
+		&draft.EndDate,
+		&draft.Adults,
+		&draft.Children,
+		&reservationID,
+		&draft.ExpiresAt,
+		&draft.CreatedAt,
+		&draft.UpdatedAt,
+	)
+	if err != nil {
+		return draft, err
+	}
+	draft.ReservationID = int(reservationID.Int64)
+
+	// the rooms keep the order they were chosen in, the first is the room of the reservation
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT room_id FROM booking_draft_rooms WHERE booking_draft_id = $1 ORDER BY id`, id)
+	if err != nil {
+		return draft, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		if err := rows.Scan(&roomID); err != nil {
+			return draft, err
+		}
+		draft.RoomIDs = append(draft.RoomIDs, roomID)
+	}
+
+	return draft, rows.Err()
+}
+
+// SetBookingDraftRooms replaces the rooms of a booking draft and holds them until expiresAt, the holds of the
+// rooms chosen before are released. Rooms held for another guest or booked for the dates
+// give repository.ErrRoomNotAvailable, the draft keeps its rooms then.
+// Drafts that do not exist or were booked give sql.ErrNoRows
+func (m *PostgresDBRepo) SetBookingDraftRooms(ctx context.Context, id string, roomIDs []int, expiresAt time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var start, end time.Time
+	err = tx.QueryRowContext(ctx,
+		`SELECT start_date, end_date FROM booking_drafts WHERE id = $1 AND reservation_id IS NULL FOR UPDATE`,
+		id).Scan(&start, &end)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from booking_draft_rooms where booking_draft_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where booking_draft_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = holdRooms(ctx, tx, id, roomIDs, start, end, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`update booking_drafts set expires_at = $1, updated_at = $2 where id = $3`,
+		expiresAt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// holdRooms adds the rooms to a booking draft and holds them for its dates until expiresAt, with a restriction
+// of the hold type. Like BookRoom it locks the rooms first and checks they are free,
+// repository.ErrRoomNotAvailable is returned otherwise
+func holdRooms(ctx context.Context, tx *sql.Tx, draftID string, roomIDs []int, start, end, expiresAt time.Time) error {
+	if len(roomIDs) == 0 {
+		return nil
+	}
+
+	_, err := lockRooms(ctx, tx, roomIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, roomID := range roomIDs {
+		available, err := roomIsFreeExcept(ctx, tx, roomID, start, end, 0, draftID)
+		if err != nil {
+			return err
+		}
+		if !available {
+			return repository.ErrRoomNotAvailable
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`insert into booking_draft_rooms (booking_draft_id, room_id, created_at, updated_at) values ($1, $2, $3, $4)`,
+			draftID, roomID, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			insert into room_restrictions
+				(start_date, end_date, room_id, restriction_id, booking_draft_id, expires_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			start, end, roomID, models.RestrictionHold, draftID, expiresAt, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteExpiredBookingDrafts removes the booking drafts that expired before the given time, booked or not,
+// and returns how many were removed
+func (m *PostgresDBRepo) DeleteExpiredBookingDrafts(ctx context.Context, expiredBefore time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from booking_drafts where expires_at < $1`, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// DeleteExpiredHolds removes the holds that expired by now, the availability queries already ignore them,
+// and returns how many were removed
+func (m *PostgresDBRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`delete from room_restrictions where restriction_id = $1 and expires_at <= $2`,
+		models.RestrictionHold, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -1,42 +1,625 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/bangn/bookings/internal/helpers"
 	"github.com/bangn/bookings/internal/models"
+	"github.com/bangn/bookings/internal/repository"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns every user of the system
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	return users, nil
+}
+
+// GetUserByID knows user 1, an admin, and user 2, a regular user
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	var u models.User
+	switch id {
+	case 1:
+		u.FirstName = "Admin"
+		u.Email = "admin@example.com"
+		u.AccessLevel = models.AccessLevelAdmin
+	case 2:
+		u.FirstName = "Jane"
+		u.Email = "jane@example.com"
+		u.AccessLevel = models.AccessLevelUser
+	default:
+		return u, sql.ErrNoRows
+	}
+	u.ID = id
+	return u, nil
+}
+
+// Authenticate only accepts admin@example.com with the password "password"
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (models.User, error) {
+	if email != "admin@example.com" || testPassword != "password" {
+		return models.User{}, repository.ErrInvalidCredentials
+	}
+	return m.GetUserByID(ctx, 1)
 }
 
 
 // InsertReservation inserts a reservation into the database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 
 	return 0, nil
 }
 
 // InsertRoomRestriction inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) (error) {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (error) {
 	return nil
 }
 
 
+// BookRoom books a reservation, room 100 is always taken and room 1000 fails.
+// Guests are checked against the capacity of testRooms, the draft of the reservation is marked booked
+func (m *testDBRepo) BookRoom(ctx context.Context, res models.Reservation) (int, error) {
+	if res.DraftID != "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.drafts[res.DraftID].IsBooked() {
+			return 0, repository.ErrBookingDraftBooked
+		}
+	}
+
+	capacity := 0
+	for _, roomID := range res.RoomIDs() {
+		if roomID == 100 {
+			return 0, repository.ErrRoomNotAvailable
+		}
+		if roomID == 1000 {
+			return 0, errors.New("failed to book room")
+		}
+		room, _ := m.GetRoomByID(ctx, roomID)
+		capacity += room.Capacity
+	}
+	if res.Guests() > capacity {
+		return 0, repository.ErrOverCapacity
+	}
+	if res.PromoCodeID == 4 {
+		return 0, repository.ErrPromoCodeUnavailable
+	}
+	// reservations booked from a draft are kept for their summary, from id 1001 on
+	if draft, ok := m.drafts[res.DraftID]; ok {
+		res.ID = 1001 + len(m.reservations)
+		m.reservations[res.ID] = res
+		draft.ReservationID = res.ID
+		m.drafts[res.DraftID] = draft
+		return res.ID, nil
+	}
+	return 1, nil
+}
+
 // SearchAvailabilityByDatesByRoomId
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error) {
 	return false, nil
 }
 
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range.
+// Every room is free in 2051, none before
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 	var rooms []models.Room
+	if start.Year() == 2051 {
+		rooms = testRooms()
+	}
 	return  rooms, nil
 }
 
-// GetRoomByID gets a room by ID
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+// GetRoomByID gets a room by ID, ids above 1000 do not exist
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
+	if id > 1000 {
+		return room, sql.ErrNoRows
+	}
+	for _, rm := range testRooms() {
+		if rm.ID == id {
+			return rm, nil
+		}
+	}
+	room.ID = id
 	return room, nil
-}
\ No newline at end of file
+}
+
+// AllReservations returns a slice of all reservations
+func (m *testDBRepo) AllReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+// AllNewReservations returns a slice of the reservations waiting for staff to confirm them, reservation 5
+func (m *testDBRepo) AllNewReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error) {
+	res, err := m.GetReservationByID(ctx, 5)
+	return []models.Reservation{res}, err
+}
+
+// GetReservationByID returns one reservation by ID, reservation 4 expired while its guest was paying,
+// reservation 5 is new, other ids above 2 do not exist but those booked from a draft. Reservations 1, 2
+// and 4 cost 240 USD
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	m.mu.Lock()
+	res, ok := m.reservations[id]
+	m.mu.Unlock()
+	if ok {
+		return res, nil
+	}
+	if id == 4 {
+		res.ID = id
+		res.RoomID = 1
+		res.Status = models.ReservationCancelled
+		res.CancelledAt = time.Now()
+		res.Total = 24000
+		res.Currency = "USD"
+		return res, nil
+	}
+	if id == 5 {
+		res.ID = id
+		res.RoomID = 1
+		res.Room = models.Room{ID: 1, RoomName: "General's Quarters"}
+		res.Status = models.ReservationNew
+		return res, nil
+	}
+	if id > 2 {
+		return res, sql.ErrNoRows
+	}
+	res.ID = id
+	res.RoomID = 1
+	res.Room = models.Room{ID: 1, RoomName: "General's Quarters"}
+	res.Status = models.ReservationConfirmed
+	res.Total = 24000
+	res.Currency = "USD"
+	return res, nil
+}
+
+// GetReservationByToken returns a reservation by its guest token, "valid-token" is an active reservation,
+// "paid-token" an active reservation that was paid,
+// "pending-token" one waiting for its payment, "expiring-token" one waiting for its payment that expires
+// while it is paid and "cancelled-token" a cancelled one
+func (m *testDBRepo) GetReservationByToken(ctx context.Context, token string) (models.Reservation, error) {
+	var res models.Reservation
+	switch token {
+	case "valid-token":
+		res, _ = m.GetReservationByID(ctx, 1)
+	case "pending-token":
+		res, _ = m.GetReservationByID(ctx, 1)
+		res.Status = models.ReservationPendingPayment
+		res.Total = 24000
+		res.Currency = "USD"
+	case "paid-token":
+		res, _ = m.GetReservationByID(ctx, 1)
+		res.PaymentIntentID = "fake_pi_paid"
+	case "expiring-token":
+		res, _ = m.GetReservationByID(ctx, 4)
+		res.Status = models.ReservationPendingPayment
+		res.CancelledAt = time.Time{}
+		res.Total = 24000
+		res.Currency = "USD"
+	case "cancelled-token":
+		res, _ = m.GetReservationByID(ctx, 2)
+		res.Status = models.ReservationCancelled
+		res.CancelledAt = time.Now()
+	default:
+		return res, sql.ErrNoRows
+	}
+	res.Token = token
+	res.StartDate = time.Now().AddDate(0, 0, 10)
+	res.EndDate = time.Now().AddDate(0, 0, 12)
+	return res, nil
+}
+
+// ChangeReservationDates moves a reservation to new dates, reservation 2 can never be moved
+// and reservation 4 expires while it is changed
+func (m *testDBRepo) ChangeReservationDates(ctx context.Context, id int, start, end time.Time, total, discount int) error {
+	switch id {
+	case 2:
+		return repository.ErrRoomNotAvailable
+	case 4:
+		return repository.ErrReservationNotChangeable
+	}
+	return nil
+}
+
+// CancelReservation cancels a reservation
+func (m *testDBRepo) CancelReservation(ctx context.Context, id int, actor models.Actor) error {
+	return m.UpdateReservationStatus(ctx, id, models.ReservationCancelled, actor)
+}
+
+// UpdateReservationStatus checks the transition from the status GetReservationByID returns
+func (m *testDBRepo) UpdateReservationStatus(ctx context.Context, id int, status string, actor models.Actor) error {
+	res, err := m.GetReservationByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !models.CanTransition(res.Status, status) {
+		return fmt.Errorf("%w: %s to %s", repository.ErrInvalidStatusTransition, res.Status, status)
+	}
+	return nil
+}
+
+// GetReservationEvents returns the creation and the confirmation of a reservation
+func (m *testDBRepo) GetReservationEvents(ctx context.Context, id int) ([]models.ReservationEvent, error) {
+	return []models.ReservationEvent{
+		{ID: 1, ReservationID: id, ToStatus: models.ReservationPendingPayment, Actor: models.ActorGuest.Name, CreatedAt: time.Now()},
+		{ID: 2, ReservationID: id, FromStatus: models.ReservationPendingPayment, ToStatus: models.ReservationConfirmed,
+			Actor: "staff", UserID: 1, User: models.User{ID: 1, FirstName: "Admin", LastName: "User"}, CreatedAt: time.Now()},
+	}, nil
+}
+
+// SetReservationPaymentIntent stores the payment intent of a reservation
+func (m *testDBRepo) SetReservationPaymentIntent(ctx context.Context, id int, intentID string) error {
+	return nil
+}
+
+// GetReservationByPaymentIntent knows "fake_pi_pending", paid for reservation 1, and "fake_pi_expired",
+// paid for reservation 2 after it expired
+func (m *testDBRepo) GetReservationByPaymentIntent(ctx context.Context, intentID string) (models.Reservation, error) {
+	var res models.Reservation
+	switch intentID {
+	case "fake_pi_pending":
+		res, _ = m.GetReservationByID(ctx, 1)
+		res.Status = models.ReservationPendingPayment
+	case "fake_pi_expired":
+		res, _ = m.GetReservationByID(ctx, 2)
+		res.Status = models.ReservationCancelled
+		res.CancelledAt = time.Now()
+	default:
+		return res, sql.ErrNoRows
+	}
+	res.PaymentIntentID = intentID
+	return res, nil
+}
+
+// ConfirmReservationPayment confirms reservation 1, every other one is not pending
+func (m *testDBRepo) ConfirmReservationPayment(ctx context.Context, id int) (bool, error) {
+	return id == 1, nil
+}
+
+// RefundReservation marks a reservation as refunded
+func (m *testDBRepo) RefundReservation(ctx context.Context, id int, actor models.Actor) error {
+	return nil
+}
+
+// ExpirePendingReservations cancels the unpaid reservations created before the given time
+func (m *testDBRepo) ExpirePendingReservations(ctx context.Context, createdBefore time.Time) (int, error) {
+	return 0, nil
+}
+
+// AllRooms returns the two rooms of the inn
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	return testRooms(), nil
+}
+
+// testRooms is the catalogue of the test repository
+func testRooms() []models.Room {
+	return []models.Room{
+		{
+			ID:            1,
+			RoomName:      "General's Quarters",
+			Slug:          "generals-quarters",
+			Description:   "Your home away form home.",
+			Capacity:      2,
+			NightlyPrice:  12000,
+			Images:        []models.RoomImage{{Path: "/static/images/generals-quarters.png"}},
+			CalendarToken: "generals-calendar-token",
+		},
+		{
+			ID:            2,
+			RoomName:      "Major's Suite",
+			Slug:          "majors-suite",
+			Description:   "Your home away form home.",
+			Capacity:      4,
+			NightlyPrice:  18000,
+			Images:        []models.RoomImage{{Path: "/static/images/marjors-suite.png"}},
+			CalendarToken: "majors-calendar-token",
+		},
+	}
+}
+
+// GetRoomBySlug knows the slugs of testRooms
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	for _, rm := range testRooms() {
+		if rm.Slug == slug {
+			return rm, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// InsertRoom adds a room to the catalogue
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	return 3, nil
+}
+
+// SetRoomCalendarToken replaces the calendar token of a room, ids above 1000 do not exist
+func (m *testDBRepo) SetRoomCalendarToken(ctx context.Context, id int, token string) error {
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateRoom saves a room, ids above 1000 do not exist
+func (m *testDBRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if room.ID > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteRoom removes a room, room 1 has reservations and ids above 1000 do not exist
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	switch {
+	case id == 1:
+		return repository.ErrRoomHasReservations
+	case id > 1000:
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetRestrictionsForRoomByDate returns, for room 1, a two night reservation starting on the 2nd, an owner block
+// on the 5th, a block imported from feed 1 on the 10th and 11th and a hold on the 15th of the month of start
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	if roomID != 1 {
+		return restrictions, nil
+	}
+
+	first := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	restrictions = append(restrictions,
+		models.RoomRestriction{
+			ID:            1,
+			RoomID:        1,
+			ReservationID: 1,
+			RestrictionID: models.RestrictionReservation,
+			StartDate:     first.AddDate(0, 0, 1),
+			EndDate:       first.AddDate(0, 0, 3),
+			Reservations:  models.Reservation{ID: 1, FirstName: "John", LastName: "Smith"},
+		},
+		models.RoomRestriction{
+			ID:            2,
+			RoomID:        1,
+			RestrictionID: models.RestrictionOwnerBlock,
+			StartDate:     first.AddDate(0, 0, 4),
+			EndDate:       first.AddDate(0, 0, 5),
+		},
+		models.RoomRestriction{
+			ID:             3,
+			RoomID:         1,
+			RestrictionID:  models.RestrictionOwnerBlock,
+			StartDate:      first.AddDate(0, 0, 9),
+			EndDate:        first.AddDate(0, 0, 11),
+			Source:         models.SourceICal,
+			CalendarFeedID: 1,
+			ExternalUID:    "airbnb-1@example.com",
+		},
+		models.RoomRestriction{
+			ID:            4,
+			RoomID:        1,
+			RestrictionID: models.RestrictionHold,
+			StartDate:     first.AddDate(0, 0, 14),
+			EndDate:       first.AddDate(0, 0, 15),
+			ExpiresAt:     time.Now().Add(15 * time.Minute),
+		},
+	)
+	return restrictions, nil
+}
+
+// InsertBlockForRoom blocks one night of a room for the owner
+func (m *testDBRepo) InsertBlockForRoom(ctx context.Context, roomID int, date time.Time) error {
+	return nil
+}
+
+// DeleteBlockNight releases one night of an owner block
+func (m *testDBRepo) DeleteBlockNight(ctx context.Context, id int, date time.Time) error {
+	return nil
+}
+
+// testCalendarFeeds are the calendar feeds of room 1, feed 1 reads a fixture of the icalsync package
+func testCalendarFeeds() []models.RoomCalendarFeed {
+	return []models.RoomCalendarFeed{
+		{ID: 1, RoomID: 1, Name: "Airbnb", URL: "../icalsync/testdata/airbnb.ics"},
+		{ID: 2, RoomID: 1, Name: "Broken", URL: "../icalsync/testdata/invalid.ics", LastError: "not an iCalendar file"},
+	}
+}
+
+// AllRoomCalendarFeeds returns the calendar feeds of every room
+func (m *testDBRepo) AllRoomCalendarFeeds(ctx context.Context) ([]models.RoomCalendarFeed, error) {
+	return testCalendarFeeds(), nil
+}
+
+// GetRoomCalendarFeeds returns the calendar feeds of a room, only room 1 has some
+func (m *testDBRepo) GetRoomCalendarFeeds(ctx context.Context, roomID int) ([]models.RoomCalendarFeed, error) {
+	var feeds []models.RoomCalendarFeed
+	for _, f := range testCalendarFeeds() {
+		if f.RoomID == roomID {
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds, nil
+}
+
+// GetRoomCalendarFeedByID returns one of testCalendarFeeds
+func (m *testDBRepo) GetRoomCalendarFeedByID(ctx context.Context, id int) (models.RoomCalendarFeed, error) {
+	for _, f := range testCalendarFeeds() {
+		if f.ID == id {
+			return f, nil
+		}
+	}
+	return models.RoomCalendarFeed{}, sql.ErrNoRows
+}
+
+// InsertRoomCalendarFeed adds a calendar feed to a room
+func (m *testDBRepo) InsertRoomCalendarFeed(ctx context.Context, feed models.RoomCalendarFeed) (int, error) {
+	return 3, nil
+}
+
+// DeleteRoomCalendarFeed removes a calendar feed
+func (m *testDBRepo) DeleteRoomCalendarFeed(ctx context.Context, id int) error {
+	return nil
+}
+
+// ReplaceImportedBlocks reports every block as added
+func (m *testDBRepo) ReplaceImportedBlocks(ctx context.Context, feed models.RoomCalendarFeed, blocks []models.RoomRestriction) (models.ImportResult, error) {
+	return models.ImportResult{Added: len(blocks)}, nil
+}
+
+// SetRoomCalendarFeedSynced records when a calendar feed was last synced
+func (m *testDBRepo) SetRoomCalendarFeedSynced(ctx context.Context, id int, syncedAt time.Time, syncErr string) error {
+	return nil
+}
+
+// AllAPIKeys returns every API key
+func (m *testDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	return keys, nil
+}
+
+// InsertAPIKey stores a new API key
+func (m *testDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey, keyHash string) (int, error) {
+	return 1, nil
+}
+
+// GetAPIKeyByHash knows "test-admin-key" with admin scope, "test-user-key" with user scope,
+// and "test-demoted-key", an admin scoped key of a regular user
+func (m *testDBRepo) GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error) {
+	var key models.APIKey
+
+	switch keyHash {
+	case helpers.HashToken("test-admin-key"):
+		key = models.APIKey{ID: 1, UserID: 1, Scope: models.AccessLevelAdmin}
+	case helpers.HashToken("test-user-key"):
+		key = models.APIKey{ID: 2, UserID: 2, Scope: models.AccessLevelUser}
+	case helpers.HashToken("test-demoted-key"):
+		key = models.APIKey{ID: 3, UserID: 2, Scope: models.AccessLevelAdmin}
+	default:
+		return key, sql.ErrNoRows
+	}
+
+	key.User, _ = m.GetUserByID(ctx, key.UserID)
+	return key, nil
+}
+
+// RevokeAPIKey revokes an API key
+func (m *testDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	return nil
+}
+
+// GetRatesForRoom knows one rate: the summer 2050 season of room 2, with a minimum stay of 3 nights
+func (m *testDBRepo) GetRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRate, error) {
+	var rates []models.RoomRate
+	if roomID == 2 {
+		rates = append(rates, models.RoomRate{
+			ID:           1,
+			RoomID:       2,
+			Name:         "Summer",
+			StartDate:    time.Date(2050, time.July, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:      time.Date(2050, time.September, 1, 0, 0, 0, 0, time.UTC),
+			WeekdayPrice: 25000,
+			MinNights:    3,
+		})
+	}
+	return rates, nil
+}
+
+// testPromoCodes are the promo codes known to the test repo, RACE passes every check but BookRoom finds it used up
+func testPromoCodes() []models.PromoCode {
+	return []models.PromoCode{
+		{ID: 1, Code: "SUMMER10", Kind: models.PromoPercent, Value: 10},
+		{ID: 2, Code: "GENERALS50", Kind: models.PromoFixed, Value: 5000, RoomIDs: []int{1}},
+		{ID: 3, Code: "EXPIRED", Kind: models.PromoPercent, Value: 10, ValidUntil: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 4, Code: "RACE", Kind: models.PromoPercent, Value: 10, MaxRedemptions: 1},
+	}
+}
+
+// GetPromoCodeByCode returns one of testPromoCodes
+func (m *testDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	for _, promo := range testPromoCodes() {
+		if strings.EqualFold(promo.Code, code) {
+			return promo, nil
+		}
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// GetPromoCodeByID returns one of testPromoCodes
+func (m *testDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	for _, promo := range testPromoCodes() {
+		if promo.ID == id {
+			return promo, nil
+		}
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// InsertBookingDraft keeps the draft, room 99 is always held for another guest
+func (m *testDBRepo) InsertBookingDraft(ctx context.Context, draft models.BookingDraft) error {
+	if slices.Contains(draft.RoomIDs, 99) {
+		return repository.ErrRoomNotAvailable
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	draft.CreatedAt = time.Now()
+	draft.UpdatedAt = time.Now()
+	m.drafts[draft.ID] = draft
+	return nil
+}
+
+// GetBookingDraft returns a draft kept by InsertBookingDraft
+func (m *testDBRepo) GetBookingDraft(ctx context.Context, id string) (models.BookingDraft, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	draft, ok := m.drafts[id]
+	if !ok {
+		return draft, sql.ErrNoRows
+	}
+	return draft, nil
+}
+
+// SetBookingDraftRooms replaces the rooms of a draft that is not booked yet, room 99 is always held for another guest
+// and room 98 was taken since the drafts holding it were made
+func (m *testDBRepo) SetBookingDraftRooms(ctx context.Context, id string, roomIDs []int, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	draft, ok := m.drafts[id]
+	if !ok || draft.IsBooked() {
+		return sql.ErrNoRows
+	}
+	if slices.Contains(roomIDs, 99) || slices.Contains(roomIDs, 98) {
+		return repository.ErrRoomNotAvailable
+	}
+	draft.RoomIDs = roomIDs
+	draft.ExpiresAt = expiresAt
+	draft.UpdatedAt = time.Now()
+	m.drafts[id] = draft
+	return nil
+}
+
+// DeleteExpiredBookingDrafts forgets the drafts that expired before the given time
+func (m *testDBRepo) DeleteExpiredBookingDrafts(ctx context.Context, expiredBefore time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, draft := range m.drafts {
+		if draft.ExpiresAt.Before(expiredBefore) {
+			delete(m.drafts, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// DeleteExpiredHolds has no holds to remove, the drafts release theirs when they expire
+func (m *testDBRepo) DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
@@ -1,18 +1,101 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/bangn/bookings/internal/models"
 )
 
-//
+// ErrInvalidCredentials is returned by Authenticate when the email is unknown or the password does not match
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrRoomNotAvailable is returned by BookRoom when the room got booked by somebody else in the meantime,
+// and when a booking draft asks for rooms held for another guest
+var ErrRoomNotAvailable = errors.New("room is no longer available for the selected dates")
+
+// ErrBookingDraftBooked is returned by BookRoom when the booking draft of the reservation was already booked,
+// the guest submitted the form twice
+var ErrBookingDraftBooked = errors.New("booking draft is already booked")
+
+// ErrOverCapacity is returned by BookRoom when the rooms of the reservation do not sleep all its guests
+var ErrOverCapacity = errors.New("the rooms can not sleep that many guests")
+
+// ErrRoomHasReservations is returned by DeleteRoom, rooms that were ever booked are kept for the history
+var ErrRoomHasReservations = errors.New("room has reservations")
+
+// ErrPromoCodeUnavailable is returned by BookRoom when the promo code of the reservation has no redemption left
+var ErrPromoCodeUnavailable = errors.New("promo code is no longer available")
+
+// ErrReservationNotChangeable is returned by ChangeReservationDates when the reservation was cancelled, refunded
+// or otherwise moved past the statuses a guest may change, see models.Reservation.CanBeChanged
+var ErrReservationNotChangeable = errors.New("reservation can no longer be changed")
+
+// ErrReservationPaid is returned by ChangeReservationDates when the payment of the reservation was taken,
+// the new dates would change a total that is already charged
+var ErrReservationPaid = errors.New("reservation is paid")
+
+// ErrInvalidStatusTransition is returned when a reservation is asked to move to a status models.CanTransition forbids
+var ErrInvalidStatusTransition = errors.New("invalid reservation status transition")
+
+// DatabaseRepo is the storage of the application. Every call takes the context of the request it serves,
+// a client going away cancels its queries, and is bounded by the QueryTimeout of the app
 type DatabaseRepo interface {
-	AllUsers() bool
+	AllUsers(ctx context.Context) ([]models.User, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	Authenticate(ctx context.Context, email, testPassword string) (models.User, error)
+
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) (error)
+	BookRoom(ctx context.Context, res models.Reservation) (int, error)
+	SearchAvailabilityByDatesByRoomId(ctx context.Context, start, end time.Time, roomId int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	SetRoomCalendarToken(ctx context.Context, id int, token string) error
+	GetRatesForRoom(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRate, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
+	GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error)
+
+	InsertBookingDraft(ctx context.Context, draft models.BookingDraft) error
+	GetBookingDraft(ctx context.Context, id string) (models.BookingDraft, error)
+	SetBookingDraftRooms(ctx context.Context, id string, roomIDs []int, expiresAt time.Time) error
+	DeleteExpiredBookingDrafts(ctx context.Context, expiredBefore time.Time) (int, error)
+	DeleteExpiredHolds(ctx context.Context, now time.Time) (int, error)
+
+	AllAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	InsertAPIKey(ctx context.Context, key models.APIKey, keyHash string) (int, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(ctx context.Context, roomID int, date time.Time) error
+	DeleteBlockNight(ctx context.Context, id int, date time.Time) error
+
+	AllRoomCalendarFeeds(ctx context.Context) ([]models.RoomCalendarFeed, error)
+	GetRoomCalendarFeeds(ctx context.Context, roomID int) ([]models.RoomCalendarFeed, error)
+	GetRoomCalendarFeedByID(ctx context.Context, id int) (models.RoomCalendarFeed, error)
+	InsertRoomCalendarFeed(ctx context.Context, feed models.RoomCalendarFeed) (int, error)
+	DeleteRoomCalendarFeed(ctx context.Context, id int) error
+	ReplaceImportedBlocks(ctx context.Context, feed models.RoomCalendarFeed, blocks []models.RoomRestriction) (models.ImportResult, error)
+	SetRoomCalendarFeedSynced(ctx context.Context, id int, syncedAt time.Time, syncErr string) error
 
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) (error)
-	SearchAvailabilityByDatesByRoomId(start, end time.Time, roomId int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
+	AllReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context, filter models.ReservationFilter) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	GetReservationByToken(ctx context.Context, token string) (models.Reservation, error)
+	ChangeReservationDates(ctx context.Context, id int, start, end time.Time, total, discount int) error
+	CancelReservation(ctx context.Context, id int, actor models.Actor) error
+	UpdateReservationStatus(ctx context.Context, id int, status string, actor models.Actor) error
+	GetReservationEvents(ctx context.Context, id int) ([]models.ReservationEvent, error)
+	SetReservationPaymentIntent(ctx context.Context, id int, intentID string) error
+	GetReservationByPaymentIntent(ctx context.Context, intentID string) (models.Reservation, error)
+	ConfirmReservationPayment(ctx context.Context, id int) (bool, error)
+	RefundReservation(ctx context.Context, id int, actor models.Actor) error
+	ExpirePendingReservations(ctx context.Context, createdBefore time.Time) (int, error)
 }
\ No newline at end of file
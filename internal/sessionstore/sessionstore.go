@@ -0,0 +1,108 @@
+// Package sessionstore keeps the sessions in Postgres, so they survive restarts and are shared by every instance
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore is an scs.CtxStore writing the sessions to the sessions table
+type PostgresStore struct {
+	DB *sql.DB
+	// Timeout bounds every query, the request the session belongs to may end it sooner
+	Timeout time.Duration
+	// Now returns the current time, sessions expired by then are not found
+	Now func() time.Time
+}
+
+// New returns a store writing to db, the queries get timeout to run
+func New(db *sql.DB, timeout time.Duration) *PostgresStore {
+	return &PostgresStore{
+		DB:      db,
+		Timeout: timeout,
+		Now:     time.Now,
+	}
+}
+
+func (s *PostgresStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.Timeout)
+}
+
+// FindCtx returns the data of a session, found is false when the session does not exist or expired
+func (s *PostgresStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT
+			data
+		FROM
+			sessions
+		WHERE
+			token = $1 AND expiry > $2`
+
+	var data []byte
+	err := s.DB.QueryRowContext(ctx, query, token, s.Now()).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// CommitCtx saves the data of a session, replacing what was saved for the same token
+func (s *PostgresStore) CommitCtx(ctx context.Context, token string, data []byte, expiry time.Time) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO sessions
+			(token, data, expiry)
+		VALUES
+			($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE SET
+			data = EXCLUDED.data, expiry = EXCLUDED.expiry`
+
+	_, err := s.DB.ExecContext(ctx, query, token, data, expiry)
+	return err
+}
+
+// DeleteCtx removes a session, removing a session that does not exist is not an error
+func (s *PostgresStore) DeleteCtx(ctx context.Context, token string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE token = $1`, token)
+	return err
+}
+
+// Find is FindCtx outside of a request
+func (s *PostgresStore) Find(token string) ([]byte, bool, error) {
+	return s.FindCtx(context.Background(), token)
+}
+
+// Commit is CommitCtx outside of a request
+func (s *PostgresStore) Commit(token string, data []byte, expiry time.Time) error {
+	return s.CommitCtx(context.Background(), token, data, expiry)
+}
+
+// Delete is DeleteCtx outside of a request
+func (s *PostgresStore) Delete(token string) error {
+	return s.DeleteCtx(context.Background(), token)
+}
+
+// DeleteExpired removes the sessions that expired, FindCtx already ignores them, and returns how many were removed
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expiry <= $1`, s.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
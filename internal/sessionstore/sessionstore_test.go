@@ -0,0 +1,260 @@
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionsDriver is a database driver keeping the sessions table in memory, one table per data source name.
+// It only knows the statements of PostgresStore, anything else is an error
+type sessionsDriver struct {
+	mu     sync.Mutex
+	tables map[string]*sessionsTable
+}
+
+type sessionRow struct {
+	data   []byte
+	expiry time.Time
+}
+
+type sessionsTable struct {
+	mu   sync.Mutex
+	rows map[string]sessionRow
+	// deadline is the deadline of the context of the last statement
+	deadline time.Time
+}
+
+func (d *sessionsDriver) Open(name string) (sqldriver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return sessionsConn{d.tables[name]}, nil
+}
+
+type sessionsConn struct {
+	table *sessionsTable
+}
+
+func (sessionsConn) Prepare(string) (sqldriver.Stmt, error) { return nil, errors.New("not supported") }
+func (sessionsConn) Close() error                           { return nil }
+func (sessionsConn) Begin() (sqldriver.Tx, error)           { return nil, errors.New("not supported") }
+
+// statement returns the query on one line, so it does not depend on the layout of the query in the store
+func statement(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func (c sessionsConn) QueryContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Rows, error) {
+	c.table.mu.Lock()
+	defer c.table.mu.Unlock()
+	c.table.deadline, _ = ctx.Deadline()
+
+	switch statement(query) {
+	case "SELECT data FROM sessions WHERE token = $1 AND expiry > $2":
+		row, ok := c.table.rows[args[0].Value.(string)]
+		if !ok || !row.expiry.After(args[1].Value.(time.Time)) {
+			return &dataRows{}, nil
+		}
+		return &dataRows{data: [][]byte{row.data}}, nil
+	}
+	return nil, fmt.Errorf("unexpected query %q", statement(query))
+}
+
+func (c sessionsConn) ExecContext(ctx context.Context, query string, args []sqldriver.NamedValue) (sqldriver.Result, error) {
+	c.table.mu.Lock()
+	defer c.table.mu.Unlock()
+	c.table.deadline, _ = ctx.Deadline()
+
+	switch statement(query) {
+	case "INSERT INTO sessions (token, data, expiry) VALUES ($1, $2, $3) ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry":
+		c.table.rows[args[0].Value.(string)] = sessionRow{data: args[1].Value.([]byte), expiry: args[2].Value.(time.Time)}
+		return sqldriver.RowsAffected(1), nil
+
+	case "DELETE FROM sessions WHERE token = $1":
+		token := args[0].Value.(string)
+		if _, ok := c.table.rows[token]; !ok {
+			return sqldriver.RowsAffected(0), nil
+		}
+		delete(c.table.rows, token)
+		return sqldriver.RowsAffected(1), nil
+
+	case "DELETE FROM sessions WHERE expiry <= $1":
+		now := args[0].Value.(time.Time)
+		var n int64
+		for token, row := range c.table.rows {
+			if !row.expiry.After(now) {
+				delete(c.table.rows, token)
+				n++
+			}
+		}
+		return sqldriver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", statement(query))
+}
+
+// dataRows are the rows of the data column
+type dataRows struct {
+	data [][]byte
+}
+
+func (r *dataRows) Columns() []string { return []string{"data"} }
+func (r *dataRows) Close() error      { return nil }
+
+func (r *dataRows) Next(dest []sqldriver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.data[0]
+	r.data = r.data[1:]
+	return nil
+}
+
+var testDriver = &sessionsDriver{tables: make(map[string]*sessionsTable)}
+
+func init() {
+	sql.Register("sessions", testDriver)
+}
+
+// now is the time of the clock of the test stores
+var now = time.Date(2050, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestStore returns a store on a sessions table of its own, holding rows, whose clock is stopped at now
+func newTestStore(t *testing.T, rows map[string]sessionRow) (*PostgresStore, *sessionsTable) {
+	t.Helper()
+
+	table := &sessionsTable{rows: make(map[string]sessionRow)}
+	for token, row := range rows {
+		table.rows[token] = row
+	}
+	testDriver.mu.Lock()
+	testDriver.tables[t.Name()] = table
+	testDriver.mu.Unlock()
+
+	db, err := sql.Open("sessions", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := New(db, time.Second)
+	store.Now = func() time.Time { return now }
+	return store, table
+}
+
+func TestPostgresStore_FindCtx(t *testing.T) {
+	store, _ := newTestStore(t, map[string]sessionRow{
+		"live":    {data: []byte("live data"), expiry: now.Add(time.Hour)},
+		"expired": {data: []byte("expired data"), expiry: now.Add(-time.Hour)},
+		"ending":  {data: []byte("ending data"), expiry: now},
+	})
+
+	var tests = []struct {
+		name          string
+		token         string
+		expectedFound bool
+		expectedData  string
+	}{
+		{"live session", "live", true, "live data"},
+		{"expired session", "expired", false, ""},
+		{"session expiring now", "ending", false, ""},
+		{"unknown token", "unknown", false, ""},
+	}
+
+	for _, e := range tests {
+		data, found, err := store.FindCtx(context.Background(), e.token)
+		if err != nil {
+			t.Errorf("%s: %v", e.name, err)
+			continue
+		}
+		if found != e.expectedFound || string(data) != e.expectedData {
+			t.Errorf("%s: got %q, found %v, wanted %q, found %v", e.name, data, found, e.expectedData, e.expectedFound)
+		}
+	}
+}
+
+func TestPostgresStore_CommitCtx(t *testing.T) {
+	store, table := newTestStore(t, nil)
+	ctx := context.Background()
+
+	err := store.CommitCtx(ctx, "token", []byte("first"), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// committing the same token again replaces its data and its expiry
+	err = store.CommitCtx(ctx, "token", []byte("second"), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(table.rows) != 1 {
+		t.Errorf("expected one session, got %d", len(table.rows))
+	}
+	if row := table.rows["token"]; !bytes.Equal(row.data, []byte("second")) || !row.expiry.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("the session was not replaced, got %q expiring at %s", row.data, row.expiry)
+	}
+
+	data, found, err := store.Find("token")
+	if err != nil || !found || string(data) != "second" {
+		t.Errorf("expected the committed data back, got %q, found %v, %v", data, found, err)
+	}
+}
+
+func TestPostgresStore_DeleteCtx(t *testing.T) {
+	store, table := newTestStore(t, map[string]sessionRow{
+		"token": {data: []byte("data"), expiry: now.Add(time.Hour)},
+		"other": {data: []byte("other data"), expiry: now.Add(time.Hour)},
+	})
+
+	if err := store.DeleteCtx(context.Background(), "token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := table.rows["token"]; ok {
+		t.Error("the session was not deleted")
+	}
+	if _, ok := table.rows["other"]; !ok {
+		t.Error("the other sessions are kept")
+	}
+
+	if err := store.Delete("unknown"); err != nil {
+		t.Errorf("deleting a session that does not exist is not an error, got %v", err)
+	}
+}
+
+func TestPostgresStore_DeleteExpired(t *testing.T) {
+	store, table := newTestStore(t, map[string]sessionRow{
+		"live":    {expiry: now.Add(time.Minute)},
+		"ending":  {expiry: now},
+		"expired": {expiry: now.Add(-time.Hour)},
+	})
+
+	n, err := store.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 sessions removed, got %d", n)
+	}
+	if _, ok := table.rows["live"]; !ok || len(table.rows) != 1 {
+		t.Errorf("only the live session is kept, got %v", table.rows)
+	}
+}
+
+func TestPostgresStore_Timeout(t *testing.T) {
+	store, table := newTestStore(t, nil)
+
+	if _, _, err := store.FindCtx(context.Background(), "token"); err != nil {
+		t.Fatal(err)
+	}
+	if table.deadline.IsZero() || time.Until(table.deadline) > store.Timeout {
+		t.Errorf("expected the query to get a deadline within the timeout, got %v", table.deadline)
+	}
+}
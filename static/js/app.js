@@ -124,7 +124,8 @@ async function checkAvailabilityForm(result, token, id) {
     });
   } else {
     attention.error({
-      msg: "No avalability",
+      // a message is only set when the request was rejected, e.g. for invalid dates
+      msg: data.message || "No avalability",
     });
   }
 }